@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"os"
 	"os/signal"
@@ -101,7 +102,17 @@ func (a *Application) Start(f func() bool) {
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGILL, syscall.SIGTRAP, syscall.SIGABRT)
-		log.Sugar.Infof("caught signal: %v", <-c)
+		for sig := range c {
+			log.Sugar.Infof("caught signal: %v", sig)
+			// SIGHUP用于重新加载证书等资源 不退出
+			if sig == syscall.SIGHUP {
+				if a.NetManager != nil {
+					a.NetManager.Reload()
+				}
+				continue
+			}
+			break
+		}
 		a.Exit()
 
 		// 等待优雅关闭完成或超时
@@ -131,6 +142,7 @@ func (a *Application) Start(f func() bool) {
 	}
 	// 网络
 	if a.NetManager != nil {
+		a.NetManager.SetActorSystem(a.ActorSystem)
 		a.NetManager.Start()
 	}
 
@@ -157,8 +169,11 @@ func (a *Application) Run() {
 }
 
 func (a *Application) End(f func()) {
-	// 网络
+	// 网络 先等所有session把消息发完再关闭
 	if a.NetManager != nil {
+		if err := a.NetManager.Drain(context.Background()); err != nil {
+			log.Sugar.Warnf("net manager drain error: %v", err)
+		}
 		a.NetManager.OnDestroy()
 	}
 	// rpc
@@ -22,16 +22,13 @@ type IConfig interface {
 func FocusConsulConfig(config IConfig) {
 	if consulClient != nil {
 		panic("SetConsul must be called after FocusConsulConfig")
-		return
 	}
 	if config == nil {
 		panic("config is nil")
-		return
 	}
 	group, ok := groups[config.Name()]
 	if ok {
 		panic("config name already exists")
-		return
 	}
 	group = &Group{
 		Name:       config.Name(),
@@ -63,12 +60,10 @@ func OnConsulConfigChange(f func(IConfig)) {
 func LoadConfig(config IConfig, tag ...string) {
 	if config == nil {
 		panic("config is nil")
-		return
 	}
 	group, ok := groups[config.Name()]
 	if ok {
 		panic("config name already exists")
-		return
 	}
 	name := config.Name()
 	path := config.Path()
@@ -1,10 +1,8 @@
 package main
 
 import (
-	"encoding/binary"
 	"example/nicepb/nice"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,80 +11,61 @@ import (
 	pnet "github.com/murang/potato/net"
 )
 
+type ClientMsgHandler struct {
+}
+
+func (h *ClientMsgHandler) IsMsgInRoutine() bool {
+	return false
+}
+
+func (h *ClientMsgHandler) OnSessionOpen(session *pnet.Session) {
+	log.Println("已连接到服务器")
+}
+
+func (h *ClientMsgHandler) OnSessionClose(session *pnet.Session) {
+	log.Println("与服务器断开连接")
+}
+
+func (h *ClientMsgHandler) OnMsg(session *pnet.Session, msg any) {
+	log.Printf("收到服务器消息: %+v", msg)
+}
+
 func main() {
-	// 连接到服务器
-	//conn, err := kcp.Dial("localhost:10086")
-	conn, err := net.Dial("tcp", "localhost:10086")
+	// 客户端和服务端使用同样的session处理流程 编解码和消息处理都复用
+	manager := pnet.NewManagerWithConfig(&pnet.Config{
+		Timeout:    30,
+		Codec:      &pnet.PbCodec{},
+		MsgHandler: &ClientMsgHandler{},
+	})
+	// 连接器 支持tcp/kcp/ws 断线后按照间隔自动重连
+	connector, err := pnet.NewConnector("tcp", "localhost:10086", pnet.WithBackoff(time.Second, 10*time.Second))
 	if err != nil {
-		log.Fatalf("连接服务器失败: %v", err)
+		log.Fatalf("创建连接器失败: %v", err)
 	}
-	defer conn.Close()
-	log.Println("已连接到服务器")
+	manager.AddConnector(connector)
+	manager.Start()
+	defer manager.OnDestroy()
 
 	// 处理退出信号
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	// 启动读取服务器消息的goroutine
-	go readServerMessages(conn)
-
 	// 主循环 - 发送消息
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-sigCh:
 			log.Println("接收到退出信号，关闭连接")
 			return
-		default:
-			// 构造消息
-			msg := &nice.C2S_Hello{
-				Name: "Potato",
-			}
-			codec := &pnet.PbCodec{}
-			bytes, err := codec.Encode(msg)
-			if err != nil {
-				log.Printf("序列化消息失败: %v", err)
-				return
+		case <-ticker.C:
+			session := connector.Session()
+			if session == nil {
+				continue
 			}
-
-			pkt := make([]byte, 4+len(bytes))
-
-			// Length
-			binary.BigEndian.PutUint32(pkt, uint32(len(bytes)))
-
-			// Value
-			copy(pkt[4:], bytes)
-
-			// 发送消息
-			if _, err := conn.Write(pkt); err != nil {
-				log.Printf("发送消息失败: %v", err)
-				return
-			}
-
-			log.Printf("已发送消息: %s", bytes)
-
-			// 等待一段时间再发送下一条消息
-			time.Sleep(1 * time.Second)
-		}
-	}
-}
-
-// 读取服务器返回的消息
-func readServerMessages(conn net.Conn) {
-	buf := make([]byte, 1024)
-	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			log.Printf("读取服务器消息失败: %v", err)
-			return
-		}
-
-		codec := &pnet.PbCodec{}
-		msg, err := codec.Decode(buf[4:n])
-		if err != nil {
-			log.Printf("反序列化消息失败: %v", err)
-			return
+			session.Send(&nice.C2S_Hello{
+				Name: "Potato",
+			})
 		}
-
-		log.Printf("收到服务器消息: %+v", msg)
 	}
 }
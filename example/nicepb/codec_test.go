@@ -0,0 +1,145 @@
+package nicepb
+
+import (
+	"encoding/binary"
+	"example/nicepb/nice"
+	"io"
+	"testing"
+
+	"github.com/murang/potato/net"
+)
+
+// 原来的收发流程和缓冲池版本的对比
+
+// 不断重复同一个包的reader
+type loopReader struct {
+	frame []byte
+	pos   int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, r.frame[r.pos:])
+	r.pos = (r.pos + n) % len(r.frame)
+	return n, nil
+}
+
+func newLoopReader(b *testing.B) *loopReader {
+	body, err := (&net.PbCodec{}).Encode(complexMsg(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	return &loopReader{frame: frame}
+}
+
+func complexMsg(b *testing.B) *nice.C2S_Complex {
+	msg := &nice.C2S_Complex{}
+	if err := msg.UnmarshalVT(testData); err != nil {
+		b.Fatal(err)
+	}
+	return msg
+}
+
+// 原来的编码 序列化之后再分配一次 把消息id和内容拷贝到一起
+func BenchmarkEncodeCopy(b *testing.B) {
+	msg := complexMsg(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, _ := msg.MarshalVT()
+		msgBytes := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(msgBytes, uint32(nice.MsgId_c2s_Complex))
+		copy(msgBytes[4:], data)
+		_ = net.WritePacket(io.Discard, msgBytes)
+	}
+}
+
+// 消息id和内容直接序列化到同一块内存
+func BenchmarkEncodeSized(b *testing.B) {
+	msg := complexMsg(b)
+	codec := &net.PbCodec{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msgBytes, _ := codec.Encode(msg)
+		_ = net.WritePacket(io.Discard, msgBytes)
+	}
+}
+
+// session发送时的方式 长度头和消息直接编码进复用的发送缓冲区
+func BenchmarkEncodeTo(b *testing.B) {
+	msg := complexMsg(b)
+	codec := &net.PbCodec{}
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		size, _ := codec.EncodedSize(msg)
+		if cap(buf) < 4+size {
+			buf = make([]byte, 4+size)
+		}
+		frame := buf[:4+size]
+		binary.BigEndian.PutUint32(frame, uint32(size))
+		_ = codec.EncodeTo(frame[4:], msg)
+		_, _ = io.Discard.Write(frame)
+	}
+}
+
+// 原来的接收 每个包分配包体 每条消息新建对象
+func BenchmarkReadAlloc(b *testing.B) {
+	reader := newLoopReader(b)
+	codec := &net.PbCodec{}
+	head := make([]byte, 4)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = io.ReadFull(reader, head)
+		body := make([]byte, binary.BigEndian.Uint32(head))
+		_, _ = io.ReadFull(reader, body)
+		if _, err := codec.Decode(body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 包体来自缓冲池 解码后放回
+func BenchmarkReadPooled(b *testing.B) {
+	reader := newLoopReader(b)
+	codec := &net.PbCodec{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		body, _ := net.ReadPacket(reader)
+		if _, err := codec.Decode(body); err != nil {
+			b.Fatal(err)
+		}
+		net.ReleasePacket(body)
+	}
+}
+
+// 包体和消息对象都来自对象池
+func BenchmarkReadPooledMsg(b *testing.B) {
+	reader := newLoopReader(b)
+	codec := &net.PbCodec{PoolMsg: true}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		body, _ := net.ReadPacket(reader)
+		msg, err := codec.Decode(body)
+		if err != nil {
+			b.Fatal(err)
+		}
+		net.ReleasePacket(body)
+		codec.Release(msg)
+	}
+}
+
+/*
+goos: linux
+goarch: amd64
+pkg: example/nicepb
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkEncodeCopy     	  475332	      2418 ns/op	    1280 B/op	       2 allocs/op
+BenchmarkEncodeSized    	  522854	      2085 ns/op	     640 B/op	       1 allocs/op
+BenchmarkEncodeTo       	  769136	      1525 ns/op	       0 B/op	       0 allocs/op
+BenchmarkReadAlloc      	   95204	     13282 ns/op	    4112 B/op	     104 allocs/op
+BenchmarkReadPooled     	   89080	     12119 ns/op	    3472 B/op	     103 allocs/op
+BenchmarkReadPooledMsg  	  100201	     12312 ns/op	    3424 B/op	     102 allocs/op
+剩下的分配是C2S_Complex里嵌套的消息和repeated字段 vt反序列化时Reset之后需要重新分配
+*/
@@ -21,7 +21,7 @@ func main() {
 		ConnectLimit:   1000,
 		Timeout:        30,
 		Codec:          &net.PbCodec{},
-		MsgHandler:     &MyMsgHandler{},
+		MsgHandler:     newRouter(),
 	})
 	// 网络监听器 支持tcp/kcp/ws
 	ln, err := net.NewListener("tcp", ":10086")
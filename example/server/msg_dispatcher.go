@@ -2,37 +2,31 @@ package main
 
 import (
 	"example/nicepb/nice"
-	"reflect"
 
 	"github.com/murang/potato"
 	"github.com/murang/potato/log"
-	"google.golang.org/protobuf/proto"
+	"github.com/murang/potato/net"
 )
 
-// 用泛型定义函数 让入参可以是不同类型 方便路由不同消息
-type HandlerFunc[T proto.Message] func(agent *Agent, msg T)
-
-func WrapHandler[T proto.Message](handler HandlerFunc[T]) func(agent *Agent, msg proto.Message) {
-	return func(agent *Agent, msg proto.Message) {
-		req, ok := msg.(T)
-		if !ok {
-			return
-		}
-		handler(agent, req)
-	}
-}
-
-// 消息分发
-var msgDispatcher = map[reflect.Type]func(agent *Agent, msg proto.Message){
-	reflect.TypeOf(&nice.C2S_Hello{}): WrapHandler(Hello),
+// 消息路由 Router实现了IMsgHandler 按消息类型分发
+func newRouter() *net.Router {
+	r := net.NewRouter()
+	r.Use(net.Recovery(), net.Logging())
+	r.OnOpen(func(session *net.Session) {
+		log.Sugar.Info("router got open:", session.ID())
+	})
+	r.OnClose(func(session *net.Session) {
+		log.Sugar.Info("router got close:", session.ID())
+	})
+	net.HandleRequest(r, Hello)
 	// ...
+	return r
 }
 
-func Hello(agent *Agent, msg *nice.C2S_Hello) {
+func Hello(session *net.Session, msg *nice.C2S_Hello) (*nice.S2C_Hello, error) {
 	resp, err := potato.RequestToModule[*NiceModule](msg.Name) // 发消息到其他模块去处理逻辑
 	if err != nil {
-		log.Sugar.Errorf("request to module failed: %v", err)
-		return
+		return nil, err
 	}
-	agent.session.Send(&nice.S2C_Hello{SayHi: resp.(string)})
+	return &nice.S2C_Hello{SayHi: resp.(string)}, nil
 }
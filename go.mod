@@ -6,10 +6,12 @@ require (
 	github.com/asynkron/protoactor-go v0.0.0-20240822202345-3c0e61ca19c9
 	github.com/gorilla/websocket v1.5.3
 	github.com/hashicorp/consul/api v1.26.1
+	github.com/klauspost/compress v1.18.0
 	github.com/lmittmann/tint v1.0.3
 	github.com/samber/slog-zap/v2 v2.6.2
 	github.com/xtaci/kcp-go v4.3.4+incompatible
 	go.uber.org/zap v1.27.0
+	golang.org/x/crypto v0.22.0
 	google.golang.org/protobuf v1.33.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
@@ -62,7 +64,6 @@ require (
 	go.opentelemetry.io/otel/sdk/metric v1.21.0 // indirect
 	go.opentelemetry.io/otel/trace v1.21.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
 	golang.org/x/exp v0.0.0-20231110203233-9a3e6036ecaa // indirect
 	golang.org/x/net v0.21.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
@@ -0,0 +1,286 @@
+package net
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/murang/potato/config"
+	"github.com/murang/potato/log"
+)
+
+// 访问控制 新连接按顺序检查
+// 1. 封禁列表 Ban/Unban 运行时修改 配置中的bans单独保存 两者互不影响
+// 2. 监听器的deny和allow AddListener时通过WithDeny/WithAllow设置 allow不为空时只允许allow中的ip
+// 3. 全局的deny和allow 通过LoadACL从config包加载 可以从consul热更新
+
+type ServeOption func(*serveOptions)
+
+// 每个监听器的设置
+type serveOptions struct {
+	allow     []netip.Prefix
+	deny      []netip.Prefix
+	codec     ICodec           // 为nil则使用Config.Codec
+	negotiate []NegotiateCodec // 客户端可以选择的编解码
+	err       error            // 设置中的错误 AddListener时返回
+}
+
+// WithAllow 只允许这些ip或者网段连接 比如内部管理端口只允许内网访问 格式为"10.0.0.0/8"或者"10.0.0.1"
+// 格式错误时AddListener返回错误
+func WithAllow(cidrs ...string) ServeOption {
+	return func(o *serveOptions) {
+		prefixes, err := parsePrefixes(cidrs)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.allow = append(o.allow, prefixes...)
+	}
+}
+
+// WithDeny 拒绝这些ip或者网段连接 格式错误时AddListener返回错误
+func WithDeny(cidrs ...string) ServeOption {
+	return func(o *serveOptions) {
+		prefixes, err := parsePrefixes(cidrs)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.deny = append(o.deny, prefixes...)
+	}
+}
+
+// 解析ip或者网段 单个ip当作/32或者/128
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type accessControl struct {
+	mu    sync.RWMutex
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	bans  map[netip.Prefix]time.Time // 手动封禁 过期时间 零值为永久
+	// 配置中的封禁 每次加载配置整体替换 Unban不能解除
+	configBans []netip.Prefix
+}
+
+// 检查ip是否允许连接 返回拒绝的原因
+func (sm *Manager) checkAccess(ip string, so *serveOptions) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		// 非ip地址(比如unix socket)不做限制
+		return "", true
+	}
+	addr = addr.Unmap()
+
+	acl := &sm.acl
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	now := time.Now()
+	for p, expire := range acl.bans {
+		if p.Contains(addr) && (expire.IsZero() || now.Before(expire)) {
+			return "banned", false
+		}
+	}
+	if containsAddr(acl.configBans, addr) {
+		return "config banned", false
+	}
+	if so != nil {
+		if containsAddr(so.deny, addr) {
+			return "listener deny", false
+		}
+		if len(so.allow) > 0 && !containsAddr(so.allow, addr) {
+			return "listener not allow", false
+		}
+	}
+	if containsAddr(acl.deny, addr) {
+		return "deny", false
+	}
+	if len(acl.allow) > 0 && !containsAddr(acl.allow, addr) {
+		return "not allow", false
+	}
+	return "", true
+}
+
+// Ban 封禁ip或者网段 duration小于等于0为永久封禁 已经连接的session会被断开
+func (sm *Manager) Ban(cidr string, duration time.Duration) error {
+	p, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+	var expire time.Time
+	if duration > 0 {
+		expire = time.Now().Add(duration)
+	}
+	acl := &sm.acl
+	acl.mu.Lock()
+	if acl.bans == nil {
+		acl.bans = map[netip.Prefix]time.Time{}
+	}
+	// 顺便清理已经过期的封禁
+	now := time.Now()
+	for k, v := range acl.bans {
+		if !v.IsZero() && now.After(v) {
+			delete(acl.bans, k)
+		}
+	}
+	acl.bans[p] = expire
+	acl.mu.Unlock()
+	log.Sugar.Infof("ban %s, duration: %s", p, duration)
+	sm.kickBanned([]netip.Prefix{p})
+	return nil
+}
+
+// 断开在封禁网段中的session
+func (sm *Manager) kickBanned(prefixes []netip.Prefix) {
+	if len(prefixes) == 0 {
+		return
+	}
+	sm.sessionMap.Range(func(key, value any) bool {
+		s := value.(*Session)
+		if addr, err := netip.ParseAddr(s.ip); err == nil && containsAddr(prefixes, addr.Unmap()) {
+			log.Sugar.Infof("kick banned session: %d, ip: %s", s.ID(), s.ip)
+			s.Close()
+		}
+		return true
+	})
+}
+
+// Unban 解除封禁 需要和Ban时的参数一致 只能解除手动封禁 配置中的封禁需要修改配置
+func (sm *Manager) Unban(cidr string) error {
+	p, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+	sm.acl.mu.Lock()
+	delete(sm.acl.bans, p)
+	sm.acl.mu.Unlock()
+	log.Sugar.Infof("unban %s", p)
+	return nil
+}
+
+// SetAccessList 设置全局的allow和deny列表 会替换之前的设置
+func (sm *Manager) SetAccessList(allow, deny []string) error {
+	allowPrefixes, err := parsePrefixes(allow)
+	if err != nil {
+		return err
+	}
+	denyPrefixes, err := parsePrefixes(deny)
+	if err != nil {
+		return err
+	}
+	sm.acl.mu.Lock()
+	sm.acl.allow = allowPrefixes
+	sm.acl.deny = denyPrefixes
+	sm.acl.mu.Unlock()
+	return nil
+}
+
+// ACLConfig 访问控制配置 通过config包加载 对应的配置文件为acl.json
+//
+//	{"allow": [], "deny": ["1.2.3.0/24"], "bans": ["5.6.7.8"]}
+type ACLConfig struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	Bans  []string `json:"bans"` // 永久封禁 从配置中删除后自动解封
+	path  string
+}
+
+func (c *ACLConfig) Name() string {
+	return "acl"
+}
+
+func (c *ACLConfig) Path() string {
+	return c.path
+}
+
+func (c *ACLConfig) ValuePtr() any {
+	return c
+}
+
+func (c *ACLConfig) OnLoad() {
+}
+
+// LoadACL 通过config包加载访问控制配置 path为本地目录或者consul kv前缀
+// consul为true时关注consul上的配置 变化后自动生效 ⚠️ 需要在config.SetConsul之前调用
+func (sm *Manager) LoadACL(path string, consul bool) {
+	if consul {
+		config.FocusConsulConfig(&ACLConfig{path: path})
+		config.OnConsulConfigChange(func(c config.IConfig) {
+			if acl, ok := c.(*ACLConfig); ok {
+				sm.applyACL(acl)
+			}
+		})
+		return
+	}
+	config.LoadConfig(&ACLConfig{path: path})
+	if acl := config.GetConfig[*ACLConfig](); acl != nil {
+		sm.applyACL(acl)
+	}
+}
+
+// 配置可能从consul的回调中并发加载 整体检查通过后在锁内一次替换
+func (sm *Manager) applyACL(c *ACLConfig) {
+	allow, err := parsePrefixes(c.Allow)
+	if err != nil {
+		log.Sugar.Errorf("acl config error: %v", err)
+		return
+	}
+	deny, err := parsePrefixes(c.Deny)
+	if err != nil {
+		log.Sugar.Errorf("acl config error: %v", err)
+		return
+	}
+	bans, err := parsePrefixes(c.Bans)
+	if err != nil {
+		log.Sugar.Errorf("acl config ban error: %v", err)
+		return
+	}
+	// 配置中的封禁替换上一次配置中的封禁 手动封禁保存在bans中不受影响
+	sm.acl.mu.Lock()
+	sm.acl.allow = allow
+	sm.acl.deny = deny
+	sm.acl.configBans = bans
+	sm.acl.mu.Unlock()
+	sm.kickBanned(bans)
+	log.Sugar.Infof("acl config loaded, allow: %d, deny: %d, bans: %d", len(c.Allow), len(c.Deny), len(c.Bans))
+}
+
+// 被拒绝的连接直接关闭
+func (sm *Manager) denyConn(conn net.Conn, ip, reason string) {
+	log.Sugar.Warnf("connection denied: %s, ip: %s", reason, ip)
+	_ = conn.Close()
+}
@@ -0,0 +1,141 @@
+package net
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckAccess(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}})
+	so := &serveOptions{}
+	WithAllow("10.0.0.0/8", "192.168.1.1")(so)
+	WithDeny("10.1.0.0/16")(so)
+	cases := map[string]bool{
+		"10.0.0.1":         true,
+		"10.1.2.3":         false,
+		"192.168.1.1":      true,
+		"192.168.1.2":      false,
+		"::ffff:10.0.0.1":  true,
+		"not an ip":        true,
+		"2001:db8::1":      false,
+		"::ffff:10.1.0.10": false,
+	}
+	for ip, want := range cases {
+		if _, ok := sm.checkAccess(ip, so); ok != want {
+			t.Fatal(ip, ok)
+		}
+	}
+	// 全局列表对所有监听器生效
+	if err := sm.SetAccessList(nil, []string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sm.checkAccess("10.0.0.1", so); ok {
+		t.Fatal("global deny")
+	}
+	if err := sm.SetAccessList([]string{"bad"}, nil); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+}
+
+func TestServeOptionError(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}})
+	ln, err := NewListener("tcp", freeAddr(t, "tcp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sm.AddListener(ln, WithAllow("10.0.0.0/8", "10.0.0.0/33")); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+	if err = sm.AddListener(ln, WithDeny("bad")); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+	if len(sm.listeners) != 0 {
+		t.Fatal("listener added")
+	}
+}
+
+func TestBan(t *testing.T) {
+	sh := &echoHandler{}
+	sm, addr := startServer(t, "tcp", &Config{MsgHandler: sh}, nil)
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: &echoHandler{client: true}}, nil)
+	waitFor(t, "open", func() bool { return sh.open.Load() == 1 })
+
+	// 封禁后已经连接的session被断开 重连也被拒绝
+	if err := sm.Ban("127.0.0.0/8", 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "kicked", func() bool { return sh.close.Load() == 1 })
+	time.Sleep(200 * time.Millisecond)
+	if sh.open.Load() != 1 {
+		t.Fatal("banned ip reconnected")
+	}
+	if err := sm.Unban("127.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "reconnect", func() bool { return sh.open.Load() == 2 && c.Session() != nil })
+}
+
+func TestBanExpire(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}})
+	_ = sm.Ban("1.2.3.4", 50*time.Millisecond)
+	if _, ok := sm.checkAccess("1.2.3.4", nil); ok {
+		t.Fatal("not banned")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := sm.checkAccess("1.2.3.4", nil); !ok {
+		t.Fatal("ban not expired")
+	}
+}
+
+func TestACLConfigBans(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}})
+	banned := func(ip string) bool { _, ok := sm.checkAccess(ip, nil); return !ok }
+
+	// 手动封禁和配置中的封禁是同一个ip
+	_ = sm.Ban("1.1.1.1", 0)
+	sm.applyACL(&ACLConfig{Bans: []string{"1.1.1.1", "2.2.2.2"}})
+	if !banned("1.1.1.1") || !banned("2.2.2.2") {
+		t.Fatal("config bans")
+	}
+	// 配置中删除后 手动封禁还在
+	sm.applyACL(&ACLConfig{Bans: []string{"2.2.2.2"}})
+	if !banned("1.1.1.1") {
+		t.Fatal("manual ban removed by config")
+	}
+	// Unban解除不了配置中的封禁
+	_ = sm.Unban("2.2.2.2")
+	if !banned("2.2.2.2") {
+		t.Fatal("config ban removed by Unban")
+	}
+	_ = sm.Unban("1.1.1.1")
+	sm.applyACL(&ACLConfig{})
+	if banned("1.1.1.1") || banned("2.2.2.2") {
+		t.Fatal("bans not cleared")
+	}
+
+	// 配置有错误时整体不生效
+	sm.applyACL(&ACLConfig{Deny: []string{"3.3.3.3"}, Bans: []string{"bad"}})
+	if banned("3.3.3.3") {
+		t.Fatal("invalid config applied")
+	}
+}
+
+func TestACLConcurrentApply(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				sm.applyACL(&ACLConfig{Deny: []string{"1.2.3.0/24"}, Bans: []string{"5.6.7.8"}})
+				sm.checkAccess("5.6.7.8", nil)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, ok := sm.checkAccess("5.6.7.8", nil); ok {
+		t.Fatal("not banned")
+	}
+}
@@ -0,0 +1,83 @@
+package net
+
+import (
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/murang/potato/log"
+)
+
+// 设置Config.SessionActor后 每个session会生成一个actor 会话事件作为actor消息投递
+// actor依次收到 SessionOnOpen -> SessionOnMsg... -> SessionOnClose 之后actor停止
+// 这个模式下不再使用IMsgHandler 玩家逻辑可以按actor的方式来写 不需要加锁
+
+type SessionOnOpen struct {
+	Session *Session
+}
+type SessionOnMsg struct {
+	Session *Session
+	Msg     any
+}
+type SessionOnClose struct {
+	Session *Session
+}
+
+// SessionSend 其他actor(模块 本地grain等)发给session actor 由框架直接发送给客户端 不会到达用户的actor
+type SessionSend struct {
+	Msg any
+}
+
+// 包装用户的actor 处理框架自己的消息
+type sessionActor struct {
+	session *Session
+	inner   actor.Actor
+}
+
+func (a *sessionActor) Receive(ctx actor.Context) {
+	switch msg := ctx.Message().(type) {
+	case *SessionSend:
+		if err := a.session.Send(msg.Msg); err != nil {
+			log.Sugar.Warnf("session actor send err, sesid: %d, err: %s", a.session.ID(), err)
+		}
+		return
+	case *SessionOnMsg:
+		a.inner.Receive(ctx)
+		a.session.finishRequest(msg.Msg)
+		return
+	case *actor.Stopped:
+		// actor因为崩溃等原因停止的话 session也没法再处理消息了
+		a.inner.Receive(ctx)
+		a.session.Close()
+		return
+	}
+	a.inner.Receive(ctx)
+}
+
+// SetActorSystem 设置session actor使用的actor系统 app启动时会设置 不设置的话开启actor模式时会自己创建一个
+func (sm *Manager) SetActorSystem(system *actor.ActorSystem) {
+	sm.actorSystem = system
+}
+
+// PID session actor的PID 没有开启actor模式时为nil
+func (s *Session) PID() *actor.PID {
+	return s.pid.Load()
+}
+
+func (sm *Manager) postActorEvent(ev *SessionEvent) {
+	s := ev.Session
+	root := sm.actorSystem.Root
+	switch ev.Type {
+	case SessionOpen:
+		props := actor.PropsFromProducer(func() actor.Actor {
+			return &sessionActor{session: s, inner: sm.sessionActor(s)}
+		})
+		s.pid.Store(root.Spawn(props))
+		sm.addSession(s)
+		root.Send(s.PID(), &SessionOnOpen{Session: s})
+	case SessionMsg:
+		root.Send(s.PID(), &SessionOnMsg{Session: s, Msg: ev.Msg})
+	case SessionClose:
+		sm.removeSession(s)
+		root.Send(s.PID(), &SessionOnClose{Session: s})
+		// 处理完邮箱里的消息后停止
+		root.Poison(s.PID())
+	}
+}
@@ -0,0 +1,44 @@
+package net
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// 收到消息后通过SessionSend原样回复的session actor
+type echoActor struct {
+	events []string
+	done   *atomic.Value
+}
+
+func (a *echoActor) Receive(ctx actor.Context) {
+	switch m := ctx.Message().(type) {
+	case *SessionOnOpen:
+		a.events = append(a.events, "open")
+	case *SessionOnMsg:
+		a.events = append(a.events, "msg")
+		ctx.Send(m.Session.PID(), &SessionSend{Msg: m.Msg})
+	case *SessionOnClose:
+		a.events = append(a.events, "close")
+		a.done.Store(a.events)
+	}
+}
+
+func TestSessionActor(t *testing.T) {
+	var done atomic.Value
+	_, addr := startServer(t, "tcp", &Config{SessionActor: func(s *Session) actor.Actor { return &echoActor{done: &done} }}, nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch}, []ConnectorOption{WithReconnect(false)})
+	for i := 0; i < 10; i++ {
+		_ = c.Session().Send(i)
+	}
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 10 })
+	c.Stop()
+	waitFor(t, "actor close", func() bool { return done.Load() != nil })
+	events := done.Load().([]string)
+	if len(events) != 12 || events[0] != "open" || events[11] != "close" {
+		t.Fatal(events)
+	}
+}
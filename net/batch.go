@@ -0,0 +1,86 @@
+package net
+
+import (
+	"net"
+	"time"
+)
+
+// 合并写 写循环把发送队列中积压的消息先写进缓冲区 队列空了或者到了等待时间再一次写出
+// 减少小消息的系统调用 kcp上也能合并成更少的分段 消息顺序不变
+// ws每次写出是一条ws消息 不会合并
+
+const defaultMaxBatch = 64 * 1024
+
+// CoalesceConfig 合并写设置 只对tcp tls kcp生效 ws/wss上每条消息还是单独写出一条ws消息
+type CoalesceConfig struct {
+	MaxBatch   int           // 一次最多合并的字节数 超过后立即写出 默认64K
+	FlushDelay time.Duration // 有数据后最多等待多久写出 期间的新消息一起写出 默认0 队列空了就写出
+}
+
+type batchWriter struct {
+	conn net.Conn
+	buf  []byte
+	max  int
+}
+
+func (sm *Manager) initCoalesce(config *CoalesceConfig) {
+	if config == nil {
+		return
+	}
+	cc := *config
+	if cc.MaxBatch <= 0 {
+		cc.MaxBatch = defaultMaxBatch
+	}
+	sm.coalesce = &cc
+}
+
+// 新连接开始发送时创建 断线恢复换连接时旧缓冲区里的消息由重发补上
+func (sm *Manager) newBatchWriter(conn net.Conn) *batchWriter {
+	if sm.coalesce == nil {
+		return nil
+	}
+	if _, ok := conn.(*wsConn); ok {
+		return nil
+	}
+	return &batchWriter{conn: conn, max: sm.coalesce.MaxBatch}
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	if len(w.buf)+len(p) > w.max {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	// 比缓冲区还大的直接写出
+	if len(p) >= w.max {
+		return len(p), writeAll(w.conn, p)
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *batchWriter) buffered() int {
+	return len(w.buf)
+}
+
+func (w *batchWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := writeAll(w.conn, w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// 把合并的数据写出
+func (s *Session) flushBatch(link *sessionLink) error {
+	if link.batch == nil || link.batch.buffered() == 0 {
+		return nil
+	}
+	if s.manager.timeout != 0 {
+		if err := link.conn.SetWriteDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
+			return err
+		}
+	}
+	return link.batch.flush()
+}
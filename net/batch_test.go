@@ -0,0 +1,104 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录每次Write的连接
+type countConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestBatchWriter(t *testing.T) {
+	conn := &countConn{}
+	w := &batchWriter{conn: conn, max: 10}
+	_, _ = w.Write([]byte("abc"))
+	_, _ = w.Write([]byte("def"))
+	if len(conn.writes) != 0 || w.buffered() != 6 {
+		t.Fatal("buffered", w.buffered())
+	}
+	// 超过max先把缓冲区写出
+	_, _ = w.Write([]byte("ghijk"))
+	if len(conn.writes) != 1 || string(conn.writes[0]) != "abcdef" {
+		t.Fatal(conn.writes)
+	}
+	// 比缓冲区大的直接写出
+	_, _ = w.Write([]byte("0123456789"))
+	_ = w.flush()
+	if len(conn.writes) != 3 || string(conn.writes[1]) != "ghijk" || string(conn.writes[2]) != "0123456789" {
+		t.Fatal(conn.writes)
+	}
+}
+
+func TestBatchWriterWs(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Coalesce: &CoalesceConfig{}})
+	if sm.newBatchWriter(&wsConn{}) != nil {
+		t.Fatal("ws coalesced")
+	}
+	if sm.newBatchWriter(&countConn{}) == nil {
+		t.Fatal("tcp not coalesced")
+	}
+}
+
+// 按顺序记录收到的消息
+type seqHandler struct {
+	echoHandler
+	mu   sync.Mutex
+	msgs []any
+}
+
+func (h *seqHandler) OnMsg(s *Session, msg any) {
+	h.mu.Lock()
+	h.msgs = append(h.msgs, msg)
+	h.mu.Unlock()
+	h.echoHandler.OnMsg(s, msg)
+}
+
+func TestCoalesceOrder(t *testing.T) {
+	const n = 500
+	for _, network := range []string{"tcp", "kcp"} {
+		for _, cc := range []*CoalesceConfig{{}, {MaxBatch: 100, FlushDelay: 5 * time.Millisecond}} {
+			t.Run(network, func(t *testing.T) {
+				cfg := func(h IMsgHandler) *Config { return &Config{MsgHandler: h, Coalesce: cc} }
+				_, addr := startServer(t, network, cfg(&echoHandler{}), nil)
+				ch := &seqHandler{echoHandler: echoHandler{client: true}}
+				_, c := startClient(t, network, addr, cfg(ch), nil)
+				for i := 0; i < n; i++ {
+					_ = c.Session().Send(strconv.Itoa(i))
+				}
+				waitFor(t, "all echoed", func() bool { return ch.got.Load() == n })
+				ch.mu.Lock()
+				defer ch.mu.Unlock()
+				for i, msg := range ch.msgs {
+					if msg != strconv.Itoa(i) {
+						t.Fatal("out of order", i, msg)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCoalesceFlushDelay(t *testing.T) {
+	// 队列空了也要等到FlushDelay才写出
+	cfg := &Config{MsgHandler: &echoHandler{}, Coalesce: &CoalesceConfig{FlushDelay: 100 * time.Millisecond}}
+	_, addr := startServer(t, "tcp", cfg, nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch}, nil)
+	begin := time.Now()
+	_ = c.Session().Send("hi")
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 1 })
+	if elapsed := time.Since(begin); elapsed < 80*time.Millisecond {
+		t.Fatal("flushed before delay", elapsed)
+	}
+}
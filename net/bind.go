@@ -0,0 +1,144 @@
+package net
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/murang/potato/log"
+)
+
+var ErrUserBound = errors.New("user already bound to another session")
+
+// BindPolicy 同一个用户再次绑定时的处理
+type BindPolicy int32
+
+const (
+	BindKickOld   BindPolicy = iota // 踢掉旧的session 新的session绑定成功 默认
+	BindRejectNew                   // 旧的session还在时新的绑定失败 返回ErrUserBound
+)
+
+// BindConfig 用户绑定设置
+type BindConfig struct {
+	Policy BindPolicy
+	OnKick func(old, new *Session) // 旧session被踢掉之前调用 可以给旧session发送被顶号的通知 发送队列写完后才断开
+}
+
+type userBinding struct {
+	mu     sync.Mutex
+	users  map[any]*Session // user id -> *Session
+	policy BindPolicy
+	onKick func(old, new *Session)
+}
+
+func (sm *Manager) initBind(config *BindConfig) {
+	sm.binding.users = make(map[any]*Session)
+	if config != nil {
+		sm.binding.policy = config.Policy
+		sm.binding.onKick = config.OnKick
+	}
+}
+
+// Bind 把session绑定到用户 userID需要是可比较的类型 查找时类型也要一致 nil为解绑
+// session已经绑定了其他用户时会先解绑 session关闭时自动解绑
+func (sm *Manager) Bind(session *Session, userID any) error {
+	if userID == nil {
+		sm.Unbind(session)
+		return nil
+	}
+	b := &sm.binding
+	b.mu.Lock()
+	// 在锁里检查 保证关闭时的unbindClosed在这之后执行
+	if session.IsClosed() {
+		b.mu.Unlock()
+		return ErrSessionClosed
+	}
+	old := b.users[userID]
+	if old == session {
+		b.mu.Unlock()
+		return nil
+	}
+	if old != nil && !old.IsClosed() && b.policy == BindRejectNew {
+		b.mu.Unlock()
+		return ErrUserBound
+	}
+	if session.userID != nil && b.users[session.userID] == session {
+		delete(b.users, session.userID)
+	}
+	session.userID = userID
+	b.users[userID] = session
+	b.mu.Unlock()
+
+	if old != nil && !old.IsClosed() {
+		log.Sugar.Infof("user %v login again, kick session %d by %d", userID, old.ID(), session.ID())
+		if b.onKick != nil {
+			b.onKick(old, session)
+		}
+		// 把OnKick中发送的通知写完再断开
+		old.CloseAfterFlush(nil)
+	}
+	return nil
+}
+
+// Unbind 解除session和用户的绑定
+func (sm *Manager) Unbind(session *Session) {
+	b := &sm.binding
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if session.userID != nil && b.users[session.userID] == session {
+		delete(b.users, session.userID)
+	}
+	session.userID = nil
+}
+
+// SessionByUser 用户绑定的session
+func (sm *Manager) SessionByUser(userID any) (*Session, bool) {
+	b := &sm.binding
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.users[userID]
+	return s, ok
+}
+
+// session关闭时删除绑定 保留session上的userID OnSessionClose中还可以取到
+func (sm *Manager) unbindClosed(session *Session) {
+	b := &sm.binding
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if session.userID != nil && b.users[session.userID] == session {
+		delete(b.users, session.userID)
+	}
+}
+
+// UserID 绑定的用户 没有绑定时为nil
+func (s *Session) UserID() any {
+	b := &s.manager.binding
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return s.userID
+}
+
+// Set 设置session上的属性 可以在多个协程中使用
+func (s *Session) Set(key string, value any) {
+	s.attrs.Store(key, value)
+}
+
+// Get 获取session上的属性
+func (s *Session) Get(key string) (any, bool) {
+	return s.attrs.Load(key)
+}
+
+// Delete 删除session上的属性
+func (s *Session) Delete(key string) {
+	s.attrs.Delete(key)
+}
+
+// GetAttr 按类型获取session上的属性 不存在或者类型不对时返回零值和false
+func GetAttr[T any](s *Session, key string) (T, bool) {
+	v, ok := s.attrs.Load(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	t, ok := v.(T)
+	return t, ok
+}
@@ -0,0 +1,111 @@
+package net
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// 两个客户端连到同一个服务器 返回服务端的两个session和对应客户端的处理器
+func twoSessions(t *testing.T, cfg *Config) (*Manager, *echoHandler, [2]*Session, [2]*echoHandler) {
+	t.Helper()
+	sm, addr := startServer(t, "tcp", cfg, nil)
+	var ss [2]*Session
+	var chs [2]*echoHandler
+	for i := range ss {
+		chs[i] = &echoHandler{client: true}
+		startClient(t, "tcp", addr, &Config{MsgHandler: chs[i]}, []ConnectorOption{WithReconnect(false)})
+		waitFor(t, "server session", func() bool {
+			sm.sessionMap.Range(func(_, v any) bool {
+				if s := v.(*Session); i == 0 || s != ss[0] {
+					ss[i] = s
+				}
+				return true
+			})
+			return ss[i] != nil
+		})
+	}
+	return sm, cfg.MsgHandler.(*echoHandler), ss, chs
+}
+
+func TestBindKickOld(t *testing.T) {
+	var kicked atomic.Pointer[Session]
+	onKick := func(old, _ *Session) {
+		kicked.Store(old)
+		_ = old.Send("kicked")
+	}
+	sm, sh, ss, chs := twoSessions(t, &Config{MsgHandler: &echoHandler{}, Bind: &BindConfig{OnKick: onKick}})
+	if err := sm.Bind(ss[0], 1001); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := sm.SessionByUser(1001); !ok || s != ss[0] || ss[0].UserID() != 1001 {
+		t.Fatal("bind")
+	}
+	// 同一个用户再次登录 旧的session被踢掉
+	if err := sm.Bind(ss[1], 1001); err != nil {
+		t.Fatal(err)
+	}
+	if kicked.Load() != ss[0] {
+		t.Fatal("old session not kicked")
+	}
+	// 旧的客户端先收到OnKick中发送的通知 然后被断开
+	waitFor(t, "kick notice", func() bool { return chs[0].got.Load() == 1 })
+	if chs[0].last.Load() != "kicked" {
+		t.Fatal(chs[0].last.Load())
+	}
+	waitFor(t, "close", func() bool { return ss[0].IsClosed() && sh.close.Load() >= 1 })
+	if s, _ := sm.SessionByUser(1001); s != ss[1] {
+		t.Fatal("new session not bound")
+	}
+	// 类型不同的id是不同的用户
+	if _, ok := sm.SessionByUser(int64(1001)); ok {
+		t.Fatal("id type ignored")
+	}
+	// 关闭后自动解绑 UserID还能取到
+	ss[1].Close()
+	waitFor(t, "unbind", func() bool { _, ok := sm.SessionByUser(1001); return !ok })
+	if ss[1].UserID() != 1001 {
+		t.Fatal("user id cleared on close")
+	}
+	if err := sm.Bind(ss[1], 1002); !errors.Is(err, ErrSessionClosed) {
+		t.Fatal(err)
+	}
+}
+
+func TestBindRejectNew(t *testing.T) {
+	sm, _, ss, _ := twoSessions(t, &Config{MsgHandler: &echoHandler{}, Bind: &BindConfig{Policy: BindRejectNew}})
+	_ = sm.Bind(ss[0], "alice")
+	if err := sm.Bind(ss[1], "alice"); !errors.Is(err, ErrUserBound) {
+		t.Fatal(err)
+	}
+	if ss[0].IsClosed() {
+		t.Fatal("old session closed")
+	}
+	// 换绑其他用户时解除原来的绑定
+	_ = sm.Bind(ss[0], "bob")
+	if _, ok := sm.SessionByUser("alice"); ok {
+		t.Fatal("old user still bound")
+	}
+	if err := sm.Bind(ss[1], "alice"); err != nil {
+		t.Fatal(err)
+	}
+	_ = sm.Bind(ss[0], nil)
+	if _, ok := sm.SessionByUser("bob"); ok || ss[0].UserID() != nil {
+		t.Fatal("unbind")
+	}
+}
+
+func TestSessionAttrs(t *testing.T) {
+	s := idleSession(t, OverflowBlock)
+	s.Set("level", 10)
+	if v, ok := GetAttr[int](s, "level"); !ok || v != 10 {
+		t.Fatal(v, ok)
+	}
+	if _, ok := GetAttr[string](s, "level"); ok {
+		t.Fatal("wrong type")
+	}
+	s.Delete("level")
+	if _, ok := s.Get("level"); ok {
+		t.Fatal("delete")
+	}
+}
@@ -0,0 +1,136 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// 收发缓冲池 按2的幂分级 512B到1MB 超过的直接分配 不放回
+// 内置的IFramer读出的包体从这里分配 使用IBufferSafe的ICodec时解码后会放回
+
+const (
+	minBufferShift = 9
+	maxBufferShift = 20
+)
+
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// 放回缓冲池时装[]byte的*[]byte 复用它们避免每次放回都分配
+var bufferHolders = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// IBufferSafe 解码结果不会引用输入[]byte的ICodec可以实现这个接口 接收的包体在解码后放回缓冲池复用
+// 内置的编解码都已经实现 自定义的ICodec如果解码结果直接引用了输入数据就不要实现
+type IBufferSafe interface {
+	BufferSafe() bool
+}
+
+// ISizedEncoder 可以直接编码到调用方缓冲区的ICodec 没有加密和帧头时消息直接编码进带长度头的发送缓冲区
+type ISizedEncoder interface {
+	EncodedSize(v any) (int, error)
+	EncodeTo(buf []byte, v any) error // len(buf)为EncodedSize的结果
+}
+
+// IMsgReleaser 可以回收消息对象的ICodec 消息在IMsgHandler.OnMsg返回后回收 actor模式下不回收
+type IMsgReleaser interface {
+	Release(msg any)
+}
+
+func bufferClass(n int) int {
+	if n <= 1<<minBufferShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minBufferShift
+}
+
+// 从缓冲池取长度为n的[]byte 内容没有清零
+func getBuffer(n int) []byte {
+	class := bufferClass(n)
+	if class >= len(bufferPools) {
+		return make([]byte, n)
+	}
+	if bp, ok := bufferPools[class].Get().(*[]byte); ok {
+		b := (*bp)[:n]
+		*bp = nil
+		bufferHolders.Put(bp)
+		return b
+	}
+	return make([]byte, n, 1<<(class+minBufferShift))
+}
+
+// ReleasePacket 把ReadPacket或者内置IFramer读出的包体放回缓冲池 之后不能再使用b 不放回也没有问题 会被gc回收
+func ReleasePacket(b []byte) {
+	c := cap(b)
+	if c < 1<<minBufferShift || c&(c-1) != 0 {
+		return
+	}
+	class := bufferClass(c)
+	if class >= len(bufferPools) {
+		return
+	}
+	bp := bufferHolders.Get().(*[]byte)
+	*bp = b[:0]
+	bufferPools[class].Put(bp)
+}
+
+// 发送时直接写长度头的IFramer
+type headFramer interface {
+	headLen(bodyLen int) int
+	putHead(head []byte, bodyLen int)
+	IMaxPacketSize
+}
+
+// 接收的包体是否可以放回缓冲池 只有内置的IFramer读出的包体来自缓冲池
+func pooledRead(framer IFramer, codec ICodec) bool {
+	if _, ok := framer.(headFramer); !ok {
+		return false
+	}
+	safe, ok := codec.(IBufferSafe)
+	return ok && safe.BufferSafe()
+}
+
+var errEncode = errors.New("encode msg error")
+
+// 没有加密 帧头和断线恢复 并且使用Config.Framer时 消息可以直接编码进带长度头的发送缓冲区
+func (s *Session) canWriteDirect(link *sessionLink, item any) bool {
+	if !s.manager.directWrite || link.secure != nil || link.framer != s.manager.framer {
+		return false
+	}
+	if _, ok := item.(rawData); ok {
+		return false
+	}
+	_, ok := s.codec.(ISizedEncoder)
+	return ok
+}
+
+// 【长度头 + 编码后的消息】在同一块缓冲区中 写完放回缓冲池
+func (s *Session) writeDirect(link *sessionLink, item any) error {
+	enc := s.codec.(ISizedEncoder)
+	framer := link.framer.(headFramer)
+	size, err := enc.EncodedSize(item)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errEncode, err)
+	}
+	if size > framer.MaxPacketSize() {
+		return ErrMaxPacket
+	}
+	headLen := framer.headLen(size)
+	buf := getBuffer(headLen + size)
+	defer ReleasePacket(buf)
+	framer.putHead(buf[:headLen], size)
+	if err = enc.EncodeTo(buf[headLen:], item); err != nil {
+		return fmt.Errorf("%w: %w", errEncode, err)
+	}
+	if s.manager.timeout != 0 {
+		if err = link.conn.SetWriteDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
+			return err
+		}
+	}
+	return writeAll(link.writer(), buf)
+}
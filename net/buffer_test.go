@@ -0,0 +1,111 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestBufferPool(t *testing.T) {
+	cases := map[int]int{1: 0, 512: 0, 513: 1, 1024: 1, 1025: 2, 1 << 20: maxBufferShift - minBufferShift}
+	for n, class := range cases {
+		if got := bufferClass(n); got != class {
+			t.Fatal(n, got)
+		}
+	}
+	for _, n := range []int{10, 600, 5000, 1 << 20} {
+		b := getBuffer(n)
+		if len(b) != n || cap(b)&(cap(b)-1) != 0 {
+			t.Fatal(n, len(b), cap(b))
+		}
+		ReleasePacket(b)
+	}
+	// 超过1MB的直接分配 放回时忽略
+	if b := getBuffer(1<<20 + 1); len(b) != 1<<20+1 {
+		t.Fatal(len(b))
+	}
+	ReleasePacket(make([]byte, 1<<21))
+	ReleasePacket(make([]byte, 100))
+	ReleasePacket(nil)
+}
+
+func TestPbCodecEncodeTo(t *testing.T) {
+	regTestMsgs()
+	c := &PbCodec{}
+	msg := wrapperspb.String("potato")
+	data, err := c.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := c.EncodedSize(msg)
+	if err != nil || size != len(data) {
+		t.Fatal(size, err)
+	}
+	buf := make([]byte, size)
+	if err = c.EncodeTo(buf, msg); err != nil || !bytes.Equal(buf, data) {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(buf) != 901 {
+		t.Fatal("msg id")
+	}
+	if _, err = c.EncodedSize("not pb"); err == nil {
+		t.Fatal("unregistered msg")
+	}
+}
+
+func TestPbCodecPoolMsg(t *testing.T) {
+	regTestMsgs()
+	c := &PbCodec{PoolMsg: true}
+	data, _ := c.Encode(wrapperspb.String("potato"))
+	msg, err := c.Decode(data)
+	if err != nil || !proto.Equal(msg.(proto.Message), wrapperspb.String("potato")) {
+		t.Fatal(msg, err)
+	}
+	c.Release(msg)
+	// 对象池中取出的消息解码前会被覆盖
+	data, _ = c.Encode(wrapperspb.String(""))
+	msg, err = c.Decode(data)
+	if err != nil || msg.(*wrapperspb.StringValue).Value != "" {
+		t.Fatal(msg, err)
+	}
+}
+
+func TestPooledRead(t *testing.T) {
+	if !pooledRead(defaultFramer, &PbCodec{}) || !pooledRead(NewVarintFramer(1024), &PbJsonCodec{}) {
+		t.Fatal("builtin framer and safe codec")
+	}
+	if pooledRead(wsMessageFramer{}, &PbCodec{}) {
+		t.Fatal("ws framer")
+	}
+}
+
+func TestWriteDirect(t *testing.T) {
+	regTestMsgs()
+	// 没有帧头时pb消息直接编码进发送缓冲区
+	sm, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}, Codec: &PbCodec{}}, nil)
+	if !sm.directWrite {
+		t.Fatal("direct write disabled")
+	}
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch, Codec: &PbCodec{}}, nil)
+	s := c.Session()
+	if !s.canWriteDirect(s.currentLink(), wrapperspb.String("x")) || s.canWriteDirect(s.currentLink(), rawData("x")) {
+		t.Fatal("canWriteDirect")
+	}
+	for i := 0; i < 100; i++ {
+		_ = s.Send(wrapperspb.Int32(int32(i)))
+	}
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 100 })
+	if v := ch.last.Load().(*wrapperspb.Int32Value).Value; v != 99 {
+		t.Fatal(v)
+	}
+
+	// 有帧头的不能直接写
+	hm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Heartbeat: &HeartbeatConfig{}})
+	if hm.directWrite {
+		t.Fatal("direct write with frame head")
+	}
+}
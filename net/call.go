@@ -0,0 +1,124 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/murang/potato/log"
+)
+
+var (
+	ErrCallNotSupported = errors.New("codec not support call, use PbSeqCodec")
+	ErrNotRequest       = errors.New("msg is not a request or has been replied")
+	ErrNotConnected     = errors.New("connector not connected")
+)
+
+// CodeError 对方通过ReplyError返回的错误码
+type CodeError struct {
+	Code int32
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("request failed, code: %d", e.Code)
+}
+
+// Reply 回复请求 使用PbSeqCodec时回复会带上请求的序号 其他编解码等同于Send
+// 需要在处理这条消息时调用 处理完之后序号就清掉了 再回复等同于Send
+func (s *Session) Reply(req, resp any) error {
+	if seq, ok := s.takeRequest(req); ok {
+		return s.Send(&Envelope{Seq: seq, Reply: true, Msg: resp})
+	}
+	return s.Send(resp)
+}
+
+// ReplyError 给请求回复错误码 只有使用PbSeqCodec时可用 和Reply一样需要在处理这条消息时调用
+func (s *Session) ReplyError(req any, code int32) error {
+	if seq, ok := s.takeRequest(req); ok {
+		return s.Send(&Envelope{Seq: seq, Reply: true, Code: code})
+	}
+	return ErrNotRequest
+}
+
+// RequestSeq 请求的序号 需要在处理完之后异步回复的话 处理时先取出序号 之后发送&Envelope{Seq: seq, Reply: true, Msg: resp}
+func (s *Session) RequestSeq(req any) (uint32, bool) {
+	if atomic.LoadInt32(&s.requestCount) == 0 {
+		return 0, false
+	}
+	seq, ok := s.requests.Load(req)
+	if !ok {
+		return 0, false
+	}
+	return seq.(uint32), true
+}
+
+func (s *Session) takeRequest(req any) (uint32, bool) {
+	if atomic.LoadInt32(&s.requestCount) == 0 {
+		return 0, false
+	}
+	seq, ok := s.requests.LoadAndDelete(req)
+	if !ok {
+		return 0, false
+	}
+	atomic.AddInt32(&s.requestCount, -1)
+	return seq.(uint32), true
+}
+
+// 消息处理完后清掉请求的序号 没有回复或者用Send回复的请求不会一直留在session里
+func (s *Session) finishRequest(msg any) {
+	s.takeRequest(msg)
+}
+
+// Call 发送请求并等待回复 只有使用PbSeqCodec时可用 回复的错误码不为0时返回*CodeError
+func (s *Session) Call(ctx context.Context, req any) (any, error) {
+	if _, ok := s.codec.(*PbSeqCodec); !ok {
+		return nil, ErrCallNotSupported
+	}
+	// 序号只用低31位 0为推送 回绕时跳过
+	seq := atomic.AddUint32(&s.callSeq, 1) &^ seqReply
+	if seq == 0 {
+		seq = atomic.AddUint32(&s.callSeq, 1) &^ seqReply
+	}
+	ch := make(chan *Envelope, 1)
+	s.calls.Store(seq, ch)
+	defer s.calls.Delete(seq)
+
+	if err := s.Send(&Envelope{Seq: seq, Msg: req}); err != nil {
+		return nil, err
+	}
+	select {
+	case env := <-ch:
+		if env.Code != 0 {
+			return env.Msg, &CodeError{Code: env.Code}
+		}
+		return env.Msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.closeChan:
+		return nil, ErrSessionClosed
+	}
+}
+
+// 拆开收到的Envelope 是Call的回复的话交给等待的协程 返回nil
+// 是请求的话记下序号 等待Reply 对方的请求和自己的Call序号相同也不会混淆
+func (s *Session) onEnvelope(env *Envelope) any {
+	if env.Reply {
+		if ch, ok := s.calls.LoadAndDelete(env.Seq); ok {
+			ch.(chan *Envelope) <- env
+		} else {
+			// Call已经超时
+			log.Sugar.Warnf("session got reply without call, sesid: %d, seq: %d, code: %d", s.ID(), env.Seq, env.Code)
+		}
+		return nil
+	}
+	if env.Seq == 0 || env.Msg == nil {
+		if env.Msg == nil {
+			log.Sugar.Warnf("session got request or push without msg, sesid: %d, seq: %d, code: %d", s.ID(), env.Seq, env.Code)
+		}
+		return env.Msg
+	}
+	s.requests.Store(env.Msg, env.Seq)
+	atomic.AddInt32(&s.requestCount, 1)
+	return env.Msg
+}
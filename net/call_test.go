@@ -0,0 +1,170 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func requestCount(s *Session) int {
+	n := 0
+	s.requests.Range(func(_, _ any) bool { n++; return true })
+	return n
+}
+
+func TestCall(t *testing.T) {
+	regTestMsgs()
+	r := NewRouter()
+	HandleRequest(r, func(s *Session, req *wrapperspb.StringValue) (*wrapperspb.Int32Value, error) {
+		switch req.Value {
+		case "bad":
+			return nil, &CodeError{Code: 7}
+		case "push":
+			// 用Send回复的就是普通推送
+			_ = s.Send(wrapperspb.Int32(99))
+			return nil, nil
+		case "none":
+			return nil, nil
+		}
+		return wrapperspb.Int32(int32(len(req.Value))), nil
+	})
+	sm, addr := startServer(t, "tcp", &Config{Codec: &PbSeqCodec{}, MsgHandler: r}, nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, &Config{Codec: &PbSeqCodec{}, MsgHandler: ch}, []ConnectorOption{WithCallTimeout(200 * time.Millisecond)})
+
+	resp, err := c.Call(context.Background(), wrapperspb.String("hello"))
+	if err != nil || resp.(*wrapperspb.Int32Value).Value != 5 {
+		t.Fatal(resp, err)
+	}
+	_, err = c.Call(context.Background(), wrapperspb.String("bad"))
+	var ce *CodeError
+	if !errors.As(err, &ce) || ce.Code != 7 {
+		t.Fatal(err)
+	}
+	if _, err = c.Call(context.Background(), wrapperspb.String("push")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	if _, err = c.Call(context.Background(), wrapperspb.String("none")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	waitFor(t, "push", func() bool { return ch.got.Load() == 1 })
+
+	// 没有回复或者用Send回复的请求处理完就清掉了
+	ss := firstSession(sm)
+	waitFor(t, "requests cleared", func() bool { return requestCount(ss) == 0 })
+	if atomic.LoadInt32(&ss.requestCount) != 0 {
+		t.Fatal("request count", ss.requestCount)
+	}
+}
+
+// 处理时取出序号 之后在别的协程中回复
+type asyncReplyHandler struct {
+	echoHandler
+}
+
+func (h *asyncReplyHandler) OnMsg(s *Session, msg any) {
+	seq, ok := s.RequestSeq(msg)
+	if !ok {
+		return
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		// 处理完之后Reply已经找不到请求了
+		if err := s.ReplyError(msg, 1); !errors.Is(err, ErrNotRequest) {
+			panic(err)
+		}
+		_ = s.Send(&Envelope{Seq: seq, Reply: true, Msg: wrapperspb.Int32(1)})
+	}()
+}
+
+func TestCallAsyncReply(t *testing.T) {
+	regTestMsgs()
+	_, addr := startServer(t, "tcp", &Config{Codec: &PbSeqCodec{}, MsgHandler: &asyncReplyHandler{}}, nil)
+	_, c := startClient(t, "tcp", addr, &Config{Codec: &PbSeqCodec{}, MsgHandler: &echoHandler{client: true}}, nil)
+	resp, err := c.Call(context.Background(), wrapperspb.String("async"))
+	if err != nil || resp.(*wrapperspb.Int32Value).Value != 1 {
+		t.Fatal(resp, err)
+	}
+}
+
+// 只收不回的session actor
+type silentActor struct{}
+
+func (a *silentActor) Receive(actor.Context) {}
+
+func TestCallActorRequestsCleared(t *testing.T) {
+	regTestMsgs()
+	sm, addr := startServer(t, "tcp", &Config{Codec: &PbSeqCodec{}, SessionActor: func(*Session) actor.Actor { return &silentActor{} }}, nil)
+	_, c := startClient(t, "tcp", addr, &Config{Codec: &PbSeqCodec{}, MsgHandler: &echoHandler{client: true}}, []ConnectorOption{WithCallTimeout(50 * time.Millisecond)})
+	for i := 0; i < 5; i++ {
+		_, _ = c.Call(context.Background(), wrapperspb.String("ignored"))
+	}
+	ss := firstSession(sm)
+	waitFor(t, "requests cleared", func() bool { return requestCount(ss) == 0 })
+}
+
+func TestCallNotSupported(t *testing.T) {
+	s := routerSession(t)
+	if _, err := s.Call(context.Background(), wrapperspb.String("x")); !errors.Is(err, ErrCallNotSupported) {
+		t.Fatal(err)
+	}
+}
+
+func TestPbSeqCodecReplyFlag(t *testing.T) {
+	regTestMsgs()
+	c := &PbSeqCodec{}
+	for _, env := range []*Envelope{
+		{Seq: 7, Msg: wrapperspb.String("req")},
+		{Seq: 7, Reply: true, Msg: wrapperspb.Int32(1)},
+		{Seq: 7, Reply: true, Code: 3},
+	} {
+		data, err := c.Encode(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := c.Decode(data)
+		got := msg.(*Envelope)
+		if err != nil || got.Seq != env.Seq || got.Reply != env.Reply || got.Code != env.Code {
+			t.Fatalf("%+v %+v %v", env, got, err)
+		}
+	}
+}
+
+// 双方同时Call对方 序号一样也不会把对方的请求当成自己的回复
+func TestCallBothWays(t *testing.T) {
+	regTestMsgs()
+	router := func(mul int32) *Router {
+		r := NewRouter()
+		HandleRequest(r, func(s *Session, req *wrapperspb.StringValue) (*wrapperspb.Int32Value, error) {
+			return wrapperspb.Int32(int32(len(req.Value)) * mul), nil
+		})
+		return r
+	}
+	sm, addr := startServer(t, "tcp", &Config{Codec: &PbSeqCodec{}, MsgHandler: router(1)}, nil)
+	_, c := startClient(t, "tcp", addr, &Config{Codec: &PbSeqCodec{}, MsgHandler: router(10)}, nil)
+	waitFor(t, "server session", func() bool { return firstSession(sm) != nil })
+
+	const n = 50
+	errs := make(chan error, 2*n)
+	call := func(s *Session, want int32) {
+		for i := 0; i < n; i++ {
+			resp, err := s.Call(context.Background(), wrapperspb.String("abc"))
+			if err == nil && resp.(*wrapperspb.Int32Value).Value != want {
+				err = errors.New("reply from the wrong side")
+			}
+			errs <- err
+		}
+	}
+	go call(c.Session(), 3)
+	go call(firstSession(sm), 30)
+	for i := 0; i < 2*n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
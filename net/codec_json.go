@@ -14,3 +14,8 @@ func (c *JsonCodec) Decode(data []byte) (interface{}, error) {
 	err := json.Unmarshal(data, &v)
 	return v, err
 }
+
+// BufferSafe json解析时会拷贝数据 不引用输入数据
+func (c *JsonCodec) BufferSafe() bool {
+	return true
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/murang/potato/pb"
 	"github.com/murang/potato/pb/vt"
+	"github.com/murang/potato/pool"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -19,40 +20,64 @@ const (
 var (
 	ErrorMsgNotRegister  = errors.New("msg not register")
 	ErrorMsgTypeNotMatch = errors.New("msg type not match protobuf")
+	ErrorMsgDirection    = errors.New("msg direction not match")
 )
 
 type PbCodec struct {
+	// 解码出的消息对象从pool包的对象池中获取 OnMsg返回后回收
+	// 开启后handler中不能保存消息对象 也不能交给其他协程异步处理 需要的话先proto.Clone
+	PoolMsg bool
 }
 
-func (c *PbCodec) Encode(v interface{}) (msgBytes []byte, err error) {
-	msgType := reflect.TypeOf(v)
-
-	msgId := pb.GetIdByType(msgType)
+// 消息id和pb消息
+func pbMessage(v interface{}) (msgId uint32, msg proto.Message, err error) {
+	msgId = pb.GetIdByType(reflect.TypeOf(v))
 	if msgId == 0 {
 		err = ErrorMsgNotRegister
 		return
 	}
-
 	msg, ok := v.(proto.Message)
 	if !ok {
 		err = ErrorMsgTypeNotMatch
-		return
 	}
+	return
+}
 
-	// 消息序列化
-	data, err := vt.Marshal(msg)
+func (c *PbCodec) Encode(v interface{}) (msgBytes []byte, err error) {
+	msgId, msg, err := pbMessage(v)
 	if err != nil {
 		return
 	}
 
-	// 加上消息id
-	msgBytes = make([]byte, lenMsgId+len(data))
-	binary.BigEndian.PutUint32(msgBytes, uint32(msgId))
-	copy(msgBytes[lenMsgId:], data)
+	// 消息id和消息内容序列化到同一块内存
+	msgBytes = make([]byte, lenMsgId+vt.Size(msg))
+	binary.BigEndian.PutUint32(msgBytes, msgId)
+	err = vt.MarshalToSizedBuffer(msg, msgBytes[lenMsgId:])
 	return
 }
 
+func (c *PbCodec) EncodedSize(v interface{}) (int, error) {
+	_, msg, err := pbMessage(v)
+	if err != nil {
+		return 0, err
+	}
+	return lenMsgId + vt.Size(msg), nil
+}
+
+func (c *PbCodec) EncodeTo(buf []byte, v interface{}) error {
+	msgId, msg, err := pbMessage(v)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(buf, msgId)
+	return vt.MarshalToSizedBuffer(msg, buf[lenMsgId:])
+}
+
 func (c *PbCodec) Decode(data []byte) (msg interface{}, err error) {
+	if len(data) < lenMsgId {
+		err = ErrMinPacket
+		return
+	}
 	// 取出消息id
 	msgId := binary.BigEndian.Uint32(data)
 	msgType := pb.GetTypeById(msgId)
@@ -62,7 +87,28 @@ func (c *PbCodec) Decode(data []byte) (msg interface{}, err error) {
 	}
 
 	// 消息反序列化
-	msg = reflect.New(msgType.Elem()).Interface()
-	err = vt.Unmarshal(data[lenMsgId:], msg.(proto.Message))
+	if c.PoolMsg {
+		msg = pool.Get(msgType)
+	} else {
+		msg = reflect.New(msgType.Elem()).Interface()
+	}
+	if err = vt.Unmarshal(data[lenMsgId:], msg.(proto.Message)); err != nil {
+		if c.PoolMsg {
+			pool.Put(msgType, msg)
+		}
+		msg = nil
+	}
 	return
 }
+
+// Release 开启PoolMsg时把消息放回对象池
+func (c *PbCodec) Release(msg any) {
+	if c.PoolMsg {
+		pool.Put(reflect.TypeOf(msg), msg)
+	}
+}
+
+// BufferSafe vt反序列化时bytes和string字段都会拷贝 不引用输入数据
+func (c *PbCodec) BufferSafe() bool {
+	return true
+}
@@ -0,0 +1,92 @@
+package net
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+
+	"github.com/murang/potato/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// PbJsonCodec 用json传输注册过的pb消息 解码出来和PbCodec一样是具体的消息类型 handler不需要改
+// 默认格式为 {"id":消息id,"body":{消息内容}} 方便浏览器调试
+// IdPrefix为true时格式为 【消息id(4字节) + 消息内容json】 和PbCodec的包结构一样
+type PbJsonCodec struct {
+	IdPrefix        bool // 使用4字节消息id前缀 不使用json信封
+	UseProtoNames   bool // 字段名使用proto中的名字 默认为lowerCamelCase
+	EmitUnpopulated bool // 零值字段也输出
+}
+
+type pbJsonEnvelope struct {
+	Id   uint32          `json:"id"`
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+func (c *PbJsonCodec) Encode(v interface{}) (msgBytes []byte, err error) {
+	msgId := pb.GetIdByType(reflect.TypeOf(v))
+	if msgId == 0 {
+		err = ErrorMsgNotRegister
+		return
+	}
+	msg, ok := v.(proto.Message)
+	if !ok {
+		err = ErrorMsgTypeNotMatch
+		return
+	}
+	data, err := protojson.MarshalOptions{
+		UseProtoNames:   c.UseProtoNames,
+		EmitUnpopulated: c.EmitUnpopulated,
+	}.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	if !c.IdPrefix {
+		return json.Marshal(&pbJsonEnvelope{Id: msgId, Body: data})
+	}
+	msgBytes = make([]byte, lenMsgId+len(data))
+	binary.BigEndian.PutUint32(msgBytes, msgId)
+	copy(msgBytes[lenMsgId:], data)
+	return
+}
+
+func (c *PbJsonCodec) Decode(data []byte) (msg interface{}, err error) {
+	var msgId uint32
+	var body []byte
+	if c.IdPrefix {
+		if len(data) < lenMsgId {
+			err = ErrMinPacket
+			return
+		}
+		msgId = binary.BigEndian.Uint32(data)
+		body = data[lenMsgId:]
+	} else {
+		var env pbJsonEnvelope
+		if err = json.Unmarshal(data, &env); err != nil {
+			return
+		}
+		msgId, body = env.Id, env.Body
+	}
+	msgType := pb.GetTypeById(msgId)
+	if msgType == nil {
+		err = ErrorMsgNotRegister
+		return
+	}
+
+	// 消息反序列化 没有body的话为空消息
+	m := reflect.New(msgType.Elem()).Interface().(proto.Message)
+	if len(body) > 0 {
+		if err = (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, m); err != nil {
+			return
+		}
+	}
+	msg = m
+	return
+}
+
+// BufferSafe json和protojson解析时会拷贝数据 不引用输入数据
+func (c *PbJsonCodec) BufferSafe() bool {
+	return true
+}
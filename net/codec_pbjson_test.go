@@ -0,0 +1,86 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestPbJsonCodec(t *testing.T) {
+	regTestMsgs()
+	cases := []struct {
+		codec *PbJsonCodec
+		msg   proto.Message
+		want  string
+	}{
+		{&PbJsonCodec{}, wrapperspb.String("potato"), `{"id":901,"body":"potato"}`},
+		{&PbJsonCodec{}, wrapperspb.Int32(0), `{"id":902,"body":0}`},
+		{&PbJsonCodec{IdPrefix: true}, wrapperspb.String("potato"), `"potato"`},
+	}
+	for _, c := range cases {
+		data, err := c.codec.Encode(c.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := data
+		if c.codec.IdPrefix {
+			if binary.BigEndian.Uint32(data) != 901 {
+				t.Fatal("id prefix", data[:4])
+			}
+			body = data[lenMsgId:]
+		}
+		if string(body) != c.want {
+			t.Fatalf("encode %s", body)
+		}
+		msg, err := c.codec.Decode(data)
+		if err != nil || !proto.Equal(msg.(proto.Message), c.msg) {
+			t.Fatal(msg, err)
+		}
+	}
+}
+
+func TestPbJsonCodecDecode(t *testing.T) {
+	regTestMsgs()
+	c := &PbJsonCodec{}
+	// 没有body的是空消息
+	msg, err := c.Decode([]byte(`{"id":901}`))
+	if err != nil || msg.(*wrapperspb.StringValue).Value != "" {
+		t.Fatal(msg, err)
+	}
+	if _, err = c.Decode([]byte(`{"id":1}`)); !errors.Is(err, ErrorMsgNotRegister) {
+		t.Fatal(err)
+	}
+	if _, err = c.Decode([]byte(`not json`)); err == nil {
+		t.Fatal("invalid json decoded")
+	}
+	if _, err = (&PbJsonCodec{IdPrefix: true}).Decode([]byte{0, 0}); !errors.Is(err, ErrMinPacket) {
+		t.Fatal(err)
+	}
+	if _, err = c.Encode("not pb"); !errors.Is(err, ErrorMsgNotRegister) {
+		t.Fatal(err)
+	}
+	// 解码结果不引用输入数据
+	data := []byte(`{"id":901,"body":"potato"}`)
+	msg, _ = c.Decode(data)
+	copy(data, "xxxxxxxxxxxxxxxxxxxxxxxxxx")
+	if msg.(*wrapperspb.StringValue).Value != "potato" {
+		t.Fatal("decoded msg references input")
+	}
+}
+
+func TestPbJsonSession(t *testing.T) {
+	regTestMsgs()
+	// handler收到的和PbCodec一样是具体的消息类型
+	cfg := func(h IMsgHandler) *Config { return &Config{MsgHandler: h, Codec: &PbJsonCodec{}} }
+	_, addr := startServer(t, "ws", cfg(&echoHandler{}), nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "ws", addr, cfg(ch), nil)
+	_ = c.Session().Send(wrapperspb.String("potato"))
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 1 })
+	if v, ok := ch.last.Load().(*wrapperspb.StringValue); !ok || v.Value != "potato" {
+		t.Fatal(ch.last.Load())
+	}
+}
@@ -9,41 +9,69 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// PbPairCodec c2s和s2c使用同一个消息id 通过pb.RegisterMsgPair注册
+// 服务端解码c2s 编码s2c 客户端(Client为true 比如机器人 网关)解码s2c 编码c2s
 type PbPairCodec struct {
+	Client bool
 }
 
-func (c *PbPairCodec) Encode(v interface{}) (msgBytes []byte, err error) {
-	msgType := reflect.TypeOf(v)
-
-	msgId := pb.GetIdByType(msgType)
-	if msgId == 0 {
-		err = ErrorMsgNotRegister
+// 客户端只能发送c2s 服务端和以前一样可以编码任何注册过的消息
+func (c *PbPairCodec) message(v interface{}) (msgId uint32, msg proto.Message, err error) {
+	if msgId, msg, err = pbMessage(v); err != nil {
 		return
 	}
+	if c.Client && reflect.TypeOf(v) != pb.GetC2STypeById(msgId) {
+		err = ErrorMsgDirection
+	}
+	return
+}
 
-	msg, ok := v.(proto.Message)
-	if !ok {
-		err = ErrorMsgTypeNotMatch
-		return
+// c2s为true时取c2s的类型 否则取s2c的类型
+func (c *PbPairCodec) typeById(msgId uint32, c2s bool) reflect.Type {
+	if c2s {
+		return pb.GetC2STypeById(msgId)
 	}
+	return pb.GetS2CTypeById(msgId)
+}
 
-	// 消息序列化
-	data, err := vt.Marshal(msg)
+func (c *PbPairCodec) Encode(v interface{}) (msgBytes []byte, err error) {
+	msgId, msg, err := c.message(v)
 	if err != nil {
 		return
 	}
 
-	// 加上消息id
-	msgBytes = make([]byte, lenMsgId+len(data))
-	binary.BigEndian.PutUint32(msgBytes, uint32(msgId))
-	copy(msgBytes[lenMsgId:], data)
+	// 消息id和消息内容序列化到同一块内存
+	msgBytes = make([]byte, lenMsgId+vt.Size(msg))
+	binary.BigEndian.PutUint32(msgBytes, msgId)
+	err = vt.MarshalToSizedBuffer(msg, msgBytes[lenMsgId:])
 	return
 }
 
+func (c *PbPairCodec) EncodedSize(v interface{}) (int, error) {
+	_, msg, err := c.message(v)
+	if err != nil {
+		return 0, err
+	}
+	return lenMsgId + vt.Size(msg), nil
+}
+
+func (c *PbPairCodec) EncodeTo(buf []byte, v interface{}) error {
+	msgId, msg, err := c.message(v)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(buf, msgId)
+	return vt.MarshalToSizedBuffer(msg, buf[lenMsgId:])
+}
+
 func (c *PbPairCodec) Decode(data []byte) (msg interface{}, err error) {
+	if len(data) < lenMsgId {
+		err = ErrMinPacket
+		return
+	}
 	// 取出消息id
 	msgId := binary.BigEndian.Uint32(data)
-	msgType := pb.GetC2STypeById(msgId) // 和PbCodec不一样 这里需要区别是c2s还是s2c
+	msgType := c.typeById(msgId, !c.Client) // 和PbCodec不一样 这里需要区别是c2s还是s2c
 	if msgType == nil {
 		err = ErrorMsgNotRegister
 		return
@@ -54,3 +82,8 @@ func (c *PbPairCodec) Decode(data []byte) (msg interface{}, err error) {
 	err = vt.Unmarshal(data[lenMsgId:], msg.(proto.Message))
 	return
 }
+
+// BufferSafe vt反序列化时会拷贝数据 不引用输入数据
+func (c *PbPairCodec) BufferSafe() bool {
+	return true
+}
@@ -0,0 +1,86 @@
+package net
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestPbPairCodec(t *testing.T) {
+	regTestMsgs()
+	server, client := &PbPairCodec{}, &PbPairCodec{Client: true}
+
+	// c2s和s2c使用同一个消息id 按照方向解码成不同的类型
+	data, err := client.Encode(wrapperspb.UInt64(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := server.Decode(data)
+	if err != nil || !proto.Equal(msg.(proto.Message), wrapperspb.UInt64(7)) {
+		t.Fatal(msg, err)
+	}
+	data, err = server.Encode(wrapperspb.Bool(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = client.Decode(data)
+	if err != nil || !proto.Equal(msg.(proto.Message), wrapperspb.Bool(true)) {
+		t.Fatal(msg, err)
+	}
+
+	// 客户端不能发送s2c
+	if _, err = client.Encode(wrapperspb.Bool(true)); !errors.Is(err, ErrorMsgDirection) {
+		t.Fatal(err)
+	}
+	if _, err = client.EncodedSize(wrapperspb.Bool(true)); !errors.Is(err, ErrorMsgDirection) {
+		t.Fatal(err)
+	}
+	if _, err = server.Decode([]byte{0, 0}); !errors.Is(err, ErrMinPacket) {
+		t.Fatal(err)
+	}
+}
+
+func TestPbPairCodecServerUnchanged(t *testing.T) {
+	regTestMsgs()
+	// 服务端模式和以前一样 任何注册过的消息都可以编码 包括c2s和普通消息
+	server := &PbPairCodec{}
+	for _, msg := range []proto.Message{wrapperspb.UInt64(7), wrapperspb.Bool(true), wrapperspb.String("potato")} {
+		data, err := server.Encode(msg)
+		if err != nil {
+			t.Fatal(msg, err)
+		}
+		size, err := server.EncodedSize(msg)
+		if err != nil || size != len(data) {
+			t.Fatal(msg, size, err)
+		}
+	}
+	if _, err := server.Encode("not pb"); !errors.Is(err, ErrorMsgNotRegister) {
+		t.Fatal(err)
+	}
+}
+
+func TestPbPairSession(t *testing.T) {
+	regTestMsgs()
+	sh := &pairHandler{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, Codec: &PbPairCodec{}}, nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch, Codec: &PbPairCodec{Client: true}}, nil)
+	_ = c.Session().Send(wrapperspb.UInt64(7))
+	waitFor(t, "reply", func() bool { return ch.got.Load() == 1 })
+	if v, ok := ch.last.Load().(*wrapperspb.BoolValue); !ok || !v.Value {
+		t.Fatal(ch.last.Load())
+	}
+}
+
+// 收到c2s回复对应的s2c
+type pairHandler struct {
+	echoHandler
+}
+
+func (h *pairHandler) OnMsg(s *Session, msg any) {
+	if _, ok := msg.(*wrapperspb.UInt64Value); ok {
+		_ = s.Send(wrapperspb.Bool(true))
+	}
+}
@@ -0,0 +1,93 @@
+package net
+
+import (
+	"encoding/binary"
+	"reflect"
+
+	"github.com/murang/potato/pb"
+	"github.com/murang/potato/pb/vt"
+	"google.golang.org/protobuf/proto"
+)
+
+// PbSeqCodec 带请求序号和错误码的pb编解码 客户端可以把回复和请求对应起来
+// 格式为 【消息id(4字节) + 序号(4字节) + 错误码(4字节) + 消息内容bytes】
+// 序号为0表示推送 不对应任何请求 只有错误码没有消息内容时消息id为0
+// 序号的最高位为1表示回复 双方可以同时Call对方 请求和回复的序号互不干扰
+type PbSeqCodec struct {
+}
+
+const (
+	lenSeqHead = lenMsgId + 8
+	seqReply   = uint32(1) << 31
+)
+
+// Envelope PbSeqCodec解码出的消息 session会拆开后只把Msg交给MsgHandler
+// 发送时直接Send普通消息的话序号为0
+type Envelope struct {
+	Seq   uint32 // 请求的序号 只用低31位
+	Reply bool   // 是对方请求的回复
+	Code  int32
+	Msg   any
+}
+
+func (c *PbSeqCodec) Encode(v interface{}) (msgBytes []byte, err error) {
+	env, ok := v.(*Envelope)
+	if !ok {
+		env = &Envelope{Msg: v}
+	}
+
+	var msgId uint32
+	var msg proto.Message
+	size := 0
+	if env.Msg != nil {
+		if msgId, msg, err = pbMessage(env.Msg); err != nil {
+			return
+		}
+		size = vt.Size(msg)
+	}
+
+	msgBytes = make([]byte, lenSeqHead+size)
+	binary.BigEndian.PutUint32(msgBytes, msgId)
+	seq := env.Seq &^ seqReply
+	if env.Reply {
+		seq |= seqReply
+	}
+	binary.BigEndian.PutUint32(msgBytes[lenMsgId:], seq)
+	binary.BigEndian.PutUint32(msgBytes[lenMsgId+4:], uint32(env.Code))
+	// 消息内容直接序列化到包头后面
+	if msg != nil {
+		err = vt.MarshalToSizedBuffer(msg, msgBytes[lenSeqHead:])
+	}
+	return
+}
+
+func (c *PbSeqCodec) Decode(data []byte) (msg interface{}, err error) {
+	if len(data) < lenSeqHead {
+		return nil, ErrMinPacket
+	}
+	seq := binary.BigEndian.Uint32(data[lenMsgId:])
+	env := &Envelope{
+		Seq:   seq &^ seqReply,
+		Reply: seq&seqReply != 0,
+		Code:  int32(binary.BigEndian.Uint32(data[lenMsgId+4:])),
+	}
+	msgId := binary.BigEndian.Uint32(data)
+	if msgId == 0 {
+		return env, nil
+	}
+	msgType := pb.GetTypeById(msgId)
+	if msgType == nil {
+		err = ErrorMsgNotRegister
+		return
+	}
+
+	// 消息反序列化
+	env.Msg = reflect.New(msgType.Elem()).Interface()
+	err = vt.Unmarshal(data[lenSeqHead:], env.Msg.(proto.Message))
+	return env, err
+}
+
+// BufferSafe vt反序列化时会拷贝数据 不引用输入数据
+func (c *PbSeqCodec) BufferSafe() bool {
+	return true
+}
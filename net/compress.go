@@ -0,0 +1,188 @@
+package net
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩算法记录在帧头的第4-5位 接收方按照帧头解压 所以双方可以各自选择压缩算法
+// 开启压缩的一方在session开始时发送frameCompress【可以解压的算法(1字节 第n位表示算法n)】
+// 发送方只使用对方支持的算法 对方不支持或者还没有收到时不压缩 所以两边设置不一样也不会断开
+// 不支持某种算法的客户端(比如WebGL不想引入zstd)只要不在frameCompress中带上它就可以
+
+type CompressAlgorithm byte
+
+const (
+	CompressNone    CompressAlgorithm = iota
+	CompressDeflate                   // 兼容性最好 各个语言都有标准实现
+	CompressSnappy                    // 速度最快 压缩率较低
+	CompressZstd                      // 压缩率最高 推荐服务器之间和原生客户端使用
+)
+
+const (
+	frameCompress byte = 0x05
+
+	frameCompressMask  byte = 0x30
+	frameCompressShift      = 4
+)
+
+var ErrDecompress = errors.New("decompress packet failed")
+
+// CompressionConfig 包体压缩设置 超过阈值的包体才压缩 对方没有开启或者不支持Algorithm时不压缩
+type CompressionConfig struct {
+	Algorithm CompressAlgorithm   // 默认使用的压缩算法
+	Accept    []CompressAlgorithm // 可以解压的算法 会告诉对方 默认全部支持
+	Threshold int                 // 包体超过多少字节才压缩 默认1024
+	MaxSize   int                 // 解压后的最大长度 防止压缩炸弹 默认16MB
+}
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+// zstd的编解码器比较重 每个Manager共用一份 EncodeAll和DecodeAll可以并发调用
+type zstdCoder struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func (sm *Manager) initCompression(config *CompressionConfig) {
+	if config == nil {
+		return
+	}
+	cc := *config
+	if cc.Threshold <= 0 {
+		cc.Threshold = 1024
+	}
+	if cc.MaxSize <= 0 {
+		cc.MaxSize = 16 * 1024 * 1024
+	}
+	if len(cc.Accept) == 0 {
+		cc.Accept = []CompressAlgorithm{CompressDeflate, CompressSnappy, CompressZstd}
+	}
+	sm.compression = &cc
+	for _, alg := range cc.Accept {
+		sm.compressAccept |= compressBit(alg)
+	}
+	sm.zstd.encoder, _ = zstd.NewWriter(nil)
+	sm.zstd.decoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(uint64(cc.MaxSize)))
+}
+
+func compressBit(alg CompressAlgorithm) byte {
+	if alg == CompressNone || alg > CompressZstd {
+		return 0
+	}
+	return 1 << alg
+}
+
+// SetCompression 设置这个session发送时使用的压缩算法 没有开启压缩或者对方不支持时无效
+func (s *Session) SetCompression(alg CompressAlgorithm) {
+	atomic.StoreInt32(&s.compress, int32(alg))
+}
+
+// 告诉对方自己可以解压的算法 在Start中放进控制帧队列 比业务消息先发出
+func (s *Session) sendCompressAccept() {
+	if s.manager.compression != nil {
+		s.sendCtrl(packFrame(frameCompress, []byte{s.manager.compressAccept}))
+	}
+}
+
+func (s *Session) onCompressAccept(payload []byte) error {
+	if len(payload) < 1 {
+		return ErrMinPacket
+	}
+	atomic.StoreInt32(&s.peerCompress, int32(payload[0]))
+	return nil
+}
+
+// 业务消息加上帧头 超过阈值并且对方支持的话压缩
+func (s *Session) packData(data []byte) []byte {
+	cc := s.manager.compression
+	if cc == nil || len(data) < cc.Threshold {
+		return packFrame(frameData, data)
+	}
+	alg := CompressAlgorithm(atomic.LoadInt32(&s.compress))
+	if byte(atomic.LoadInt32(&s.peerCompress))&compressBit(alg) == 0 {
+		return packFrame(frameData, data)
+	}
+	out, err := s.manager.compress(alg, data)
+	// 压缩后反而更大的话直接发原始数据
+	if err != nil || len(out) >= len(data) {
+		return packFrame(frameData, data)
+	}
+	return packFrame(frameData|byte(alg)<<frameCompressShift, out)
+}
+
+func (s *Session) unpackData(head byte, payload []byte) ([]byte, error) {
+	alg := CompressAlgorithm((head & frameCompressMask) >> frameCompressShift)
+	if alg == CompressNone {
+		return payload, nil
+	}
+	if s.manager.compression == nil || s.manager.compressAccept&compressBit(alg) == 0 {
+		return nil, ErrDecompress
+	}
+	return s.manager.decompress(alg, payload)
+}
+
+func (sm *Manager) compress(alg CompressAlgorithm, data []byte) ([]byte, error) {
+	switch alg {
+	case CompressDeflate:
+		var buf bytes.Buffer
+		w := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressSnappy:
+		return snappy.Encode(nil, data), nil
+	case CompressZstd:
+		return sm.zstd.encoder.EncodeAll(data, nil), nil
+	}
+	return nil, ErrDecompress
+}
+
+func (sm *Manager) decompress(alg CompressAlgorithm, data []byte) ([]byte, error) {
+	maxSize := sm.compression.MaxSize
+	switch alg {
+	case CompressDeflate:
+		r := flate.NewReader(bytes.NewReader(data))
+		defer r.Close()
+		out, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+		if err != nil || len(out) > maxSize {
+			return nil, ErrDecompress
+		}
+		return out, nil
+	case CompressSnappy:
+		n, err := snappy.DecodedLen(data)
+		if err != nil || n > maxSize {
+			return nil, ErrDecompress
+		}
+		out, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, ErrDecompress
+		}
+		return out, nil
+	case CompressZstd:
+		out, err := sm.zstd.decoder.DecodeAll(data, nil)
+		if err != nil || len(out) > maxSize {
+			return nil, ErrDecompress
+		}
+		return out, nil
+	}
+	return nil, ErrDecompress
+}
@@ -0,0 +1,138 @@
+package net
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Compression: &CompressionConfig{}})
+	data := bytes.Repeat([]byte("potato "), 1000)
+	for _, alg := range []CompressAlgorithm{CompressDeflate, CompressSnappy, CompressZstd} {
+		out, err := sm.compress(alg, data)
+		if err != nil || len(out) >= len(data) {
+			t.Fatal(alg, len(out), err)
+		}
+		back, err := sm.decompress(alg, out)
+		if err != nil || !bytes.Equal(back, data) {
+			t.Fatal(alg, err)
+		}
+	}
+}
+
+func TestDecompressMaxSize(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Compression: &CompressionConfig{MaxSize: 1024}})
+	bomb := make([]byte, 4096)
+	for _, alg := range []CompressAlgorithm{CompressDeflate, CompressSnappy, CompressZstd} {
+		out, err := sm.compress(alg, bomb)
+		if err != nil {
+			t.Fatal(alg, err)
+		}
+		if _, err = sm.decompress(alg, out); !errors.Is(err, ErrDecompress) {
+			t.Fatal(alg, err)
+		}
+	}
+}
+
+func TestPackDataThreshold(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Compression: &CompressionConfig{Algorithm: CompressSnappy, Threshold: 100}})
+	a, b := net.Pipe()
+	defer b.Close()
+	s := sm.NewSession(a)
+	defer s.Close()
+	alg := func(pkt []byte) CompressAlgorithm {
+		return CompressAlgorithm((pkt[0] & frameCompressMask) >> frameCompressShift)
+	}
+	// 还没有收到对方支持的算法时不压缩
+	if got := alg(s.packData(bytes.Repeat([]byte("a"), 500))); got != CompressNone {
+		t.Fatal("before accept", got)
+	}
+	_ = s.onCompressAccept([]byte{sm.compressAccept})
+	if got := alg(s.packData(bytes.Repeat([]byte("a"), 50))); got != CompressNone {
+		t.Fatal("below threshold", got)
+	}
+	if got := alg(s.packData(bytes.Repeat([]byte("a"), 500))); got != CompressSnappy {
+		t.Fatal("above threshold", got)
+	}
+	// 压缩不了的数据直接发原始数据
+	random := make([]byte, 500)
+	_, _ = rand.Read(random)
+	if pkt := s.packData(random); alg(pkt) != CompressNone || !bytes.Equal(pkt[1:], random) {
+		t.Fatal("incompressible payload", alg(pkt))
+	}
+	s.SetCompression(CompressZstd)
+	pkt := s.packData(bytes.Repeat([]byte("a"), 500))
+	if alg(pkt) != CompressZstd {
+		t.Fatal("SetCompression", alg(pkt))
+	}
+	back, err := s.unpackData(pkt[0], pkt[1:])
+	if err != nil || len(back) != 500 {
+		t.Fatal(len(back), err)
+	}
+	// 对方不支持的算法不使用
+	_ = s.onCompressAccept([]byte{compressBit(CompressDeflate)})
+	if got := alg(s.packData(bytes.Repeat([]byte("a"), 500))); got != CompressNone {
+		t.Fatal("peer not accept", got)
+	}
+}
+
+func TestCompressSession(t *testing.T) {
+	// 双方各自选择压缩算法 接收方按帧头解压
+	sh := &echoHandler{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, Compression: &CompressionConfig{Algorithm: CompressZstd}}, nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch, Compression: &CompressionConfig{Algorithm: CompressDeflate}}, nil)
+	big := strings.Repeat("potato ", 1000)
+	_ = c.Session().Send(big)
+	_ = c.Session().Send("small")
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 2 })
+	if ch.last.Load() != "small" {
+		t.Fatal(ch.last.Load())
+	}
+	if sh.close.Load() != 0 {
+		t.Fatal("session closed")
+	}
+}
+
+func TestCompressMismatch(t *testing.T) {
+	big := strings.Repeat("potato ", 1000)
+	cases := map[string][2]*CompressionConfig{
+		// 服务端用zstd 客户端只能解压deflate 服务端发送时不压缩
+		"zstd vs deflate only": {{Algorithm: CompressZstd}, {Algorithm: CompressDeflate, Accept: []CompressAlgorithm{CompressDeflate}}},
+		// 对方没有开启压缩 不压缩
+		"compress vs none": {nil, {Algorithm: CompressSnappy}},
+		"none vs compress": {{Algorithm: CompressSnappy}, nil},
+	}
+	for name, cc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sh := &echoHandler{}
+			_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, Heartbeat: &HeartbeatConfig{}, Compression: cc[0]}, nil)
+			ch := &echoHandler{client: true}
+			_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch, Heartbeat: &HeartbeatConfig{}, Compression: cc[1]}, nil)
+			for i := 0; i < 3; i++ {
+				_ = c.Session().Send(big)
+			}
+			waitFor(t, "echo", func() bool { return ch.got.Load() == 3 })
+			if ch.last.Load() != big || sh.close.Load() != 0 {
+				t.Fatal("session closed")
+			}
+		})
+	}
+}
+
+func TestDecompressNotAccepted(t *testing.T) {
+	// 对方不按协商发送 使用了自己不支持的算法 解压失败
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Compression: &CompressionConfig{Accept: []CompressAlgorithm{CompressDeflate}}})
+	a, b := net.Pipe()
+	defer b.Close()
+	s := sm.NewSession(a)
+	defer s.Close()
+	out, _ := sm.compress(CompressZstd, bytes.Repeat([]byte("a"), 500))
+	if _, err := s.unpackData(byte(CompressZstd)<<frameCompressShift, out); !errors.Is(err, ErrDecompress) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,327 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/murang/potato/log"
+	"github.com/xtaci/kcp-go"
+)
+
+// IConnector 客户端连接器 主动连接服务器 连接成功后生成和服务端一样的Session
+type IConnector interface {
+	Start()
+	Stop()
+	Session() *Session                                           // 当前连接的session 未连接时返回nil
+	OnNewConnection(func(conn net.Conn, prev *Session) *Session) // prev为断线前的session 用于断线恢复
+	Call(ctx context.Context, req any) (any, error)              // 发送请求并等待回复 需要使用PbSeqCodec
+}
+
+type ConnectorOption func(*connectorOptions)
+
+type connectorOptions struct {
+	reconnect   bool          // 断线后是否重连
+	minBackoff  time.Duration // 重连最小间隔
+	maxBackoff  time.Duration // 重连最大间隔 每次失败间隔翻倍 直到最大间隔
+	maxRetry    int           // 连续重连失败的最大次数 0为不限制
+	dialTimeout time.Duration // 连接超时
+	tlsConfig   *tls.Config   // tls和wss使用的设置
+	kcp         *kcpOptions   // kcp参数
+	callTimeout time.Duration // Call的默认超时 ctx没有设置deadline时使用
+	wsCompress  bool          // ws是否协商permessage-deflate
+	wsProtocols []string      // ws握手时请求的子协议
+	negotiate   []byte        // 连接后发送的编解码协商字节
+}
+
+func defaultConnectorOptions() *connectorOptions {
+	return &connectorOptions{
+		reconnect:   true,
+		minBackoff:  time.Second,
+		maxBackoff:  30 * time.Second,
+		dialTimeout: 5 * time.Second,
+		callTimeout: 10 * time.Second,
+		kcp:         defaultKcpOptions(),
+	}
+}
+
+// WithReconnect 设置断线后是否自动重连 默认重连
+func WithReconnect(reconnect bool) ConnectorOption {
+	return func(o *connectorOptions) {
+		o.reconnect = reconnect
+	}
+}
+
+// WithBackoff 设置重连间隔 每次失败后间隔翻倍 直到max
+func WithBackoff(min, max time.Duration) ConnectorOption {
+	return func(o *connectorOptions) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// WithMaxRetry 设置连续重连失败的最大次数 0为不限制
+func WithMaxRetry(n int) ConnectorOption {
+	return func(o *connectorOptions) {
+		o.maxRetry = n
+	}
+}
+
+// WithDialTimeout 设置连接超时
+func WithDialTimeout(timeout time.Duration) ConnectorOption {
+	return func(o *connectorOptions) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithDialTLSConfig 设置tls和wss连接使用的tls.Config 不设置则使用系统根证书校验服务器
+func WithDialTLSConfig(config *tls.Config) ConnectorOption {
+	return func(o *connectorOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithDialKcp 设置kcp参数 FEC参数需要和服务端一致
+func WithDialKcp(opts ...KcpOption) ConnectorOption {
+	return func(o *connectorOptions) {
+		for _, opt := range opts {
+			opt(o.kcp)
+		}
+	}
+}
+
+// WithDialWsCompression ws连接协商permessage-deflate压缩 服务端需要开启WithWsCompression
+func WithDialWsCompression() ConnectorOption {
+	return func(o *connectorOptions) {
+		o.wsCompress = true
+	}
+}
+
+// WithCallTimeout 设置Call的默认超时 ctx没有设置deadline时使用 默认10秒
+func WithCallTimeout(timeout time.Duration) ConnectorOption {
+	return func(o *connectorOptions) {
+		o.callTimeout = timeout
+	}
+}
+
+// WithDialNegotiation 连接后先发送1字节的编解码id 服务端需要通过WithNegotiation设置
+func WithDialNegotiation(id byte) ConnectorOption {
+	return func(o *connectorOptions) {
+		o.negotiate = []byte{id}
+	}
+}
+
+// WithDialWsSubprotocols ws握手时请求的子协议 服务端可以按照子协议选择编解码
+func WithDialWsSubprotocols(protocols ...string) ConnectorOption {
+	return func(o *connectorOptions) {
+		o.wsProtocols = protocols
+	}
+}
+
+func NewConnector(network, addr string, opts ...ConnectorOption) (IConnector, error) {
+	switch network {
+	case "tcp", "tls", "kcp", "ws", "wss":
+	default:
+		return nil, errors.New("not support network")
+	}
+	o := defaultConnectorOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.minBackoff <= 0 {
+		o.minBackoff = time.Second
+	}
+	if o.maxBackoff < o.minBackoff {
+		o.maxBackoff = o.minBackoff
+	}
+	c := &connector{
+		network:  network,
+		addr:     addr,
+		opts:     o,
+		exitChan: make(chan struct{}),
+	}
+	return c, nil
+}
+
+type connector struct {
+	network         string
+	addr            string
+	opts            *connectorOptions
+	session         atomic.Pointer[Session]
+	exitOnce        sync.Once
+	exitChan        chan struct{}
+	onNewConnection func(net.Conn, *Session) *Session
+}
+
+func (c *connector) Start() {
+	go c.run()
+}
+
+func (c *connector) Stop() {
+	c.exitOnce.Do(func() {
+		close(c.exitChan)
+	})
+	if sess := c.session.Load(); sess != nil {
+		sess.Close()
+	}
+}
+
+func (c *connector) Session() *Session {
+	return c.session.Load()
+}
+
+func (c *connector) OnNewConnection(f func(net.Conn, *Session) *Session) {
+	c.onNewConnection = f
+}
+
+func (c *connector) Call(ctx context.Context, req any) (any, error) {
+	sess := c.session.Load()
+	if sess == nil {
+		return nil, ErrNotConnected
+	}
+	if _, ok := ctx.Deadline(); !ok && c.opts.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.callTimeout)
+		defer cancel()
+	}
+	return sess.Call(ctx, req)
+}
+
+func (c *connector) isExit() bool {
+	select {
+	case <-c.exitChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *connector) run() {
+	retry := 0
+	backoff := c.opts.minBackoff
+	var prev *Session // 等待恢复的session
+	for !c.isExit() {
+		var sess *Session
+		conn, err := c.dial()
+		if err != nil {
+			log.Sugar.Warnf("%s connect to %s failed: %v", c.network, c.addr, err)
+		} else {
+			log.Sugar.Infof("%s connected to %s", c.network, c.addr)
+			if c.onNewConnection == nil {
+				_ = conn.Close()
+				return
+			}
+			if prev != nil && prev.IsClosed() {
+				prev = nil
+			}
+			// 握手失败的时候返回nil 和连接失败一样处理
+			if sess = c.onNewConnection(conn, prev); sess == nil {
+				err = ErrHandshake
+			}
+		}
+		if err == nil {
+			retry = 0
+			backoff = c.opts.minBackoff
+			c.session.Store(sess)
+			// Stop可能发生在Store之前 这里再检查一次 避免session没人关
+			if c.isExit() {
+				sess.Close()
+			}
+			prev = nil
+			if sess.resume != nil {
+				select {
+				case <-sess.exitChan:
+				case <-sess.resume.suspendNotify:
+					// 连接断了但是session还在 重连后尝试恢复
+					prev = sess
+				}
+			} else {
+				<-sess.exitChan
+			}
+			if prev == nil {
+				c.session.CompareAndSwap(sess, nil)
+			}
+			log.Sugar.Infof("%s disconnected from %s", c.network, c.addr)
+		}
+
+		if !c.opts.reconnect {
+			return
+		}
+		if err != nil {
+			retry++
+			if c.opts.maxRetry > 0 && retry > c.opts.maxRetry {
+				log.Sugar.Errorf("%s connect to %s failed after %d retries", c.network, c.addr, c.opts.maxRetry)
+				return
+			}
+		}
+		select {
+		case <-c.exitChan:
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil {
+			backoff *= 2
+			if backoff > c.opts.maxBackoff {
+				backoff = c.opts.maxBackoff
+			}
+		}
+	}
+}
+
+func (c *connector) dial() (net.Conn, error) {
+	conn, err := c.dialNetwork()
+	if err != nil || len(c.opts.negotiate) == 0 {
+		return conn, err
+	}
+	if _, err = conn.Write(c.opts.negotiate); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *connector) dialNetwork() (net.Conn, error) {
+	switch c.network {
+	case "tcp":
+		return net.DialTimeout("tcp", c.addr, c.opts.dialTimeout)
+	case "tls":
+		dialer := &net.Dialer{Timeout: c.opts.dialTimeout}
+		return tls.DialWithDialer(dialer, "tcp", c.addr, c.opts.tlsConfig)
+	case "kcp":
+		kcpConn, err := kcp.DialWithOptions(c.addr, nil, c.opts.kcp.dataShards, c.opts.kcp.parityShards)
+		if err != nil {
+			return nil, err
+		}
+		c.opts.kcp.apply(kcpConn)
+		if c.opts.kcp.dscp > 0 {
+			_ = kcpConn.SetDSCP(c.opts.kcp.dscp)
+		}
+		return kcpConn, nil
+	case "ws", "wss":
+		url := c.addr
+		if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+			url = c.network + "://" + url + "/"
+		}
+		dialer := &websocket.Dialer{
+			HandshakeTimeout:  c.opts.dialTimeout,
+			TLSClientConfig:   c.opts.tlsConfig,
+			EnableCompression: c.opts.wsCompress,
+			Subprotocols:      c.opts.wsProtocols,
+		}
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if c.opts.wsCompress {
+			conn.EnableWriteCompression(true)
+		}
+		return newWsConn(conn, websocket.BinaryMessage, maxWsBufferSize), nil
+	}
+	return nil, errors.New("not support network")
+}
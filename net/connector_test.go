@@ -0,0 +1,77 @@
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectorEcho(t *testing.T) {
+	for _, network := range []string{"tcp", "ws", "kcp"} {
+		t.Run(network, func(t *testing.T) {
+			_, addr := startServer(t, network, &Config{MsgHandler: &echoHandler{}}, nil)
+			ch := &echoHandler{client: true}
+			_, c := startClient(t, network, addr, &Config{MsgHandler: ch}, nil)
+			for i := 0; i < 10; i++ {
+				if err := c.Session().Send(map[string]any{"i": i}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			waitFor(t, "echo", func() bool { return ch.got.Load() == 10 })
+		})
+	}
+}
+
+func TestConnectorReconnect(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	ch := &echoHandler{client: true}
+	cm := NewManagerWithConfig(&Config{MsgHandler: ch})
+	c, _ := NewConnector("tcp", addr, WithBackoff(20*time.Millisecond, 50*time.Millisecond))
+	cm.AddConnector(c)
+	cm.Start()
+	defer cm.OnDestroy()
+
+	// 服务器晚启动 连接器一直重试
+	time.Sleep(100 * time.Millisecond)
+	if c.Session() != nil {
+		t.Fatal("connected before server start")
+	}
+	sh := &echoHandler{}
+	sm := NewManagerWithConfig(&Config{MsgHandler: sh})
+	ln, err := NewListener("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.AddListener(ln)
+	sm.Start()
+	defer sm.OnDestroy()
+	waitFor(t, "connect", func() bool { return c.Session() != nil })
+	first := c.Session()
+
+	// 服务端断开后重新连接 生成新的session
+	waitFor(t, "server session", func() bool { return firstSession(sm) != nil })
+	firstSession(sm).Close()
+	waitFor(t, "reconnect", func() bool { s := c.Session(); return s != nil && s != first })
+	_ = c.Session().Send("hi")
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 1 })
+	if ch.open.Load() != 2 || ch.close.Load() != 1 {
+		t.Fatalf("open %d close %d", ch.open.Load(), ch.close.Load())
+	}
+}
+
+func TestConnectorMaxRetry(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	cm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{client: true}})
+	c, _ := NewConnector("tcp", addr, WithBackoff(10*time.Millisecond, 10*time.Millisecond), WithMaxRetry(2))
+	cm.AddConnector(c)
+	done := make(chan struct{})
+	go func() {
+		c.(*connector).run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connector did not give up")
+	}
+	c.Stop()
+}
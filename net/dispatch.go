@@ -0,0 +1,75 @@
+package net
+
+import (
+	"github.com/murang/potato/log"
+)
+
+// 会话事件按照session id分配到固定的分发协程 同一个session的open->msg->close严格有序
+// 不同session的事件在多个协程中并发处理 IMsgHandler需要注意并发安全
+// DispatchShards为1时和原来一样所有事件在一个协程中依次处理
+
+// 投递会话事件 IsMsgInRoutine时直接在session的协程中处理
+func (sm *Manager) postEvent(ev *SessionEvent) {
+	if sm.sessionActor != nil {
+		sm.postActorEvent(ev)
+		return
+	}
+	if sm.msgHandler != nil && sm.msgHandler.IsMsgInRoutine() {
+		sm.handleEvent(ev)
+		return
+	}
+	shard := sm.sessionEventChans[ev.Session.ID()%uint64(len(sm.sessionEventChans))]
+	shard <- ev
+}
+
+func (sm *Manager) handleEvent(ev *SessionEvent) {
+	switch ev.Type {
+	case SessionOpen:
+		sm.addSession(ev.Session)
+		if sm.msgHandler != nil {
+			sm.msgHandler.OnSessionOpen(ev.Session)
+		}
+	case SessionClose:
+		sm.removeSession(ev.Session)
+		if sm.msgHandler != nil {
+			sm.msgHandler.OnSessionClose(ev.Session)
+		}
+	case SessionMsg:
+		if sm.msgHandler != nil {
+			sm.msgHandler.OnMsg(ev.Session, ev.Msg)
+		}
+		ev.Session.finishRequest(ev.Msg)
+		// 处理完的消息交给编解码回收
+		if r, ok := ev.Session.codec.(IMsgReleaser); ok {
+			r.Release(ev.Msg)
+		}
+	}
+}
+
+func (sm *Manager) runDispatch(events chan *SessionEvent) {
+	for ev := range events {
+		sm.handleEvent(ev)
+	}
+}
+
+func (sm *Manager) addSession(s *Session) {
+	sm.sessionMap.Store(s.ID(), s)
+	log.Sugar.Infof("session open: %d", s.ID())
+}
+
+func (sm *Manager) removeSession(s *Session) {
+	sm.sessionMap.Delete(s.ID())
+	// 连接数在接受连接时已经增加 连接器的session不计入连接数
+	if !s.isClient {
+		sm.releaseConn(s.ip)
+	}
+	log.Sugar.Infof("session close: %d", s.ID())
+}
+
+// GetSession 根据id获取session 不存在或者已经关闭时返回nil
+func (sm *Manager) GetSession(id uint64) *Session {
+	if s, ok := sm.sessionMap.Load(id); ok {
+		return s.(*Session)
+	}
+	return nil
+}
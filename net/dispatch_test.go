@@ -0,0 +1,63 @@
+package net
+
+import (
+	"sync"
+	"testing"
+)
+
+// 按session记录事件顺序
+type orderHandler struct {
+	mu     sync.Mutex
+	events map[uint64][]any
+}
+
+func (h *orderHandler) record(s *Session, ev any) {
+	h.mu.Lock()
+	h.events[s.ID()] = append(h.events[s.ID()], ev)
+	h.mu.Unlock()
+}
+
+func (h *orderHandler) IsMsgInRoutine() bool      { return false }
+func (h *orderHandler) OnSessionOpen(s *Session)  { h.record(s, "open") }
+func (h *orderHandler) OnSessionClose(s *Session) { h.record(s, "close") }
+func (h *orderHandler) OnMsg(s *Session, msg any) { h.record(s, msg) }
+
+func TestDispatchShards(t *testing.T) {
+	sh := &orderHandler{events: map[uint64][]any{}}
+	sm, addr := startServer(t, "tcp", &Config{MsgHandler: sh, DispatchShards: 4}, nil)
+	var cs []IConnector
+	for i := 0; i < 8; i++ {
+		_, c := startClient(t, "tcp", addr, &Config{MsgHandler: &echoHandler{client: true}}, []ConnectorOption{WithReconnect(false)})
+		for j := 0; j < 50; j++ {
+			_ = c.Session().Send(float64(j))
+		}
+		cs = append(cs, c)
+	}
+	// 关闭后连接数要还回去
+	for _, c := range cs {
+		waitFor(t, "sent", func() bool { return c.Session().SendStats().Sent == 50 })
+		c.Stop()
+	}
+	waitFor(t, "all closed", func() bool {
+		sm.connMu.Lock()
+		defer sm.connMu.Unlock()
+		return sm.sessionCount == 0
+	})
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if len(sh.events) != 8 {
+		t.Fatal("sessions", len(sh.events))
+	}
+	// 同一个session的open->msg->close严格有序
+	for id, evs := range sh.events {
+		if len(evs) != 52 || evs[0] != "open" || evs[51] != "close" {
+			t.Fatal(id, len(evs), evs[0], evs[len(evs)-1])
+		}
+		for j, ev := range evs[1:51] {
+			if ev != float64(j) {
+				t.Fatal(id, j, ev)
+			}
+		}
+	}
+}
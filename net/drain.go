@@ -0,0 +1,129 @@
+package net
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+const defaultDrainTimeout = 10 * time.Second
+
+// CloseAfterFlush 发送最后一条消息(比如踢下线的原因) 把发送队列中的消息写完后关闭 reason为nil则不发送
+// 调用之后Send会返回ErrSessionClosed 队列满时阻塞的Send也会返回 超过Config.Timeout还没有写完的话直接关闭
+func (s *Session) CloseAfterFlush(reason any) {
+	if s.IsClosed() || !atomic.CompareAndSwapInt32(&s.flushing, 0, 1) {
+		return
+	}
+	// 唤醒阻塞在队列上的Send 等正在放入队列的Send都返回 之后的Send都会看到flushing
+	close(s.flushStart)
+	s.sendGuard.Lock()
+	s.sendGuard.Unlock()
+	if reason != nil {
+		if err := s.queue(reason, nil); err != nil {
+			log.Sugar.Warnf("session send close reason err: sesid: %d, err: %s", s.ID(), err.Error())
+		}
+	}
+	close(s.flushChan)
+	timeout := time.Duration(s.manager.timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	time.AfterFunc(timeout, s.Close)
+}
+
+// 把队列中剩下的消息写完 不再等待新消息
+func (s *Session) flush(link *sessionLink) {
+	for {
+		select {
+		case item := <-s.sendChan:
+			data, err := s.encodeItem(item)
+			if err != nil {
+				log.Sugar.Errorf("encode msg error, sesid: %d, err: %s", s.ID(), err)
+				return
+			}
+			// 和写循环一样 超长的消息只丢弃 继续发送后面的消息
+			pkts := s.dataPackets(data)
+			if err = s.checkPacketSize(link, pkts); err != nil {
+				log.Sugar.Errorf("drop oversize msg, sesid: %d, size: %d, err: %s", s.ID(), len(data), err)
+				continue
+			}
+			atomic.AddUint64(&s.sendCount, 1)
+			if err = s.sendPackets(link, pkts); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Drain 停止接受新连接 所有session发送Config.DrainNotice后关闭 等待它们都关闭后返回
+// 超过ctx或者Config.DrainTimeout时强制关闭剩下的session 返回超时的错误
+func (sm *Manager) Drain(ctx context.Context) error {
+	sm.draining.Store(true)
+	sm.stopListeners()
+	if sm.drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sm.drainTimeout)
+		defer cancel()
+	}
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	// 已经通知过的session 只在这个协程中使用
+	notified := map[*Session]struct{}{}
+	for {
+		// 每次都通知一遍 握手中的连接也会在变成session后收到
+		// 发送队列满时CloseAfterFlush可能阻塞BlockTimeout 每个session在单独的协程中通知
+		sm.rangeServerSessions(func(s *Session) {
+			if ctx.Err() != nil || atomic.LoadInt32(&s.flushing) != 0 {
+				return
+			}
+			if _, ok := notified[s]; ok {
+				return
+			}
+			notified[s] = struct{}{}
+			go func() {
+				var reason any
+				if sm.drainNotice != nil {
+					reason = sm.drainNotice(s)
+				}
+				s.CloseAfterFlush(reason)
+			}()
+		})
+		sm.connMu.Lock()
+		count := sm.sessionCount
+		sm.connMu.Unlock()
+		if count <= 0 {
+			log.Sugar.Info("all sessions drained")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			log.Sugar.Warnf("drain timeout, force close %d sessions", count)
+			sm.rangeServerSessions(func(s *Session) {
+				s.Close()
+			})
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sm *Manager) rangeServerSessions(f func(s *Session)) {
+	sm.sessionMap.Range(func(_, v any) bool {
+		if s := v.(*Session); !s.isClient {
+			f(s)
+		}
+		return true
+	})
+}
+
+func (sm *Manager) stopListeners() {
+	sm.stopOnce.Do(func() {
+		for _, ln := range sm.listeners {
+			ln.Stop()
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCloseAfterFlush(t *testing.T) {
+	sm, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, nil)
+	ch := &echoHandler{client: true}
+	startClient(t, "tcp", addr, &Config{MsgHandler: ch}, nil)
+	waitFor(t, "server session", func() bool { return firstSession(sm) != nil })
+	s := firstSession(sm)
+	for i := 0; i < 10; i++ {
+		_ = s.Send("msg")
+	}
+	s.CloseAfterFlush("bye")
+	if err := s.Send("late"); !errors.Is(err, ErrSessionClosed) {
+		t.Fatal(err)
+	}
+	waitFor(t, "all flushed", func() bool { return ch.got.Load() == 11 })
+	if ch.last.Load() != "bye" {
+		t.Fatal(ch.last.Load())
+	}
+	waitFor(t, "closed", func() bool { return s.IsClosed() })
+}
+
+func TestCloseAfterFlushBlockedSend(t *testing.T) {
+	// 队列满时阻塞的Send在开始flush后返回 不会排在关闭原因后面
+	sm := NewManagerWithConfig(&Config{SendQueue: &SendQueueConfig{Size: 4, BlockTimeout: -1}})
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	s := sm.NewSession(a)
+	for i := 0; i < 4; i++ {
+		_ = s.Send("msg")
+	}
+	late := make(chan error, 1)
+	go func() { late <- s.Send("late") }()
+	time.Sleep(50 * time.Millisecond)
+	go s.CloseAfterFlush("bye")
+	if err := <-late; !errors.Is(err, ErrSessionClosed) {
+		t.Fatal(err)
+	}
+	var items []any
+	for len(items) < 5 {
+		select {
+		case item := <-s.sendChan:
+			items = append(items, item)
+		case <-time.After(time.Second):
+			t.Fatal("reason not queued", items)
+		}
+	}
+	if items[4] != "bye" || len(s.sendChan) != 0 {
+		t.Fatal(items, len(s.sendChan))
+	}
+	<-s.flushChan
+	s.Close()
+}
+
+func TestDrain(t *testing.T) {
+	sh := &echoHandler{}
+	sm, addr := startServer(t, "tcp", &Config{MsgHandler: sh, DrainNotice: func(*Session) any { return "bye" }}, nil)
+	var handlers []*echoHandler
+	for i := 0; i < 3; i++ {
+		ch := &echoHandler{client: true}
+		handlers = append(handlers, ch)
+		startClient(t, "tcp", addr, &Config{MsgHandler: ch}, nil)
+	}
+	waitFor(t, "open", func() bool { return sh.open.Load() == 3 })
+	if err := sm.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range handlers {
+		if ch.got.Load() != 1 || ch.last.Load() != "bye" {
+			t.Fatal("notice", ch.got.Load())
+		}
+	}
+	// 停止接受新连接
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("listener still accepting")
+	}
+}
+
+// 测试用的监听器 新连接是net.Pipe 对端从来不读
+type pipeListener struct {
+	onNew func(net.Conn)
+	peers []net.Conn
+}
+
+func (l *pipeListener) Start()                           {}
+func (l *pipeListener) Stop()                            {}
+func (l *pipeListener) OnNewConnection(f func(net.Conn)) { l.onNew = f }
+
+func (l *pipeListener) dial(t *testing.T) {
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	l.peers = append(l.peers, b)
+	go l.onNew(a)
+}
+
+func TestDrainSlowSessions(t *testing.T) {
+	const n = 5
+	sm := NewManagerWithConfig(&Config{
+		MsgHandler:   &echoHandler{},
+		SendQueue:    &SendQueueConfig{Size: 2, BlockTimeout: 300 * time.Millisecond},
+		DrainNotice:  func(*Session) any { return "bye" },
+		DrainTimeout: -1,
+	})
+	ln := &pipeListener{}
+	sm.AddListener(ln)
+	sm.Start()
+	t.Cleanup(sm.OnDestroy)
+	for i := 0; i < n; i++ {
+		ln.dial(t)
+	}
+	var sessions []*Session
+	waitFor(t, "sessions", func() bool {
+		sessions = sessions[:0]
+		sm.rangeServerSessions(func(s *Session) { sessions = append(sessions, s) })
+		return len(sessions) == n
+	})
+	// 写协程卡在pipe上 发送队列排满
+	for _, s := range sessions {
+		for s.SendStats().QueueLen < s.SendStats().QueueCap {
+			_ = s.Send("fill")
+		}
+	}
+
+	// 依次通知的话每个session都要等BlockTimeout 这里应该在ctx超时后马上返回
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := sm.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+		t.Fatal("drain blocked", elapsed)
+	}
+	for _, s := range sessions {
+		waitFor(t, "force closed", s.IsClosed)
+	}
+}
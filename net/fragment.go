@@ -0,0 +1,61 @@
+package net
+
+// 超过分片大小的业务消息(压缩之后)会被拆成多个帧发送 帧头第6位为1表示后面还有分片
+// 同一条消息的分片在写循环中连续写出 中间只会插入控制帧 接收方在每条连接上按顺序拼接
+// 断线恢复时没有拼完的分片直接丢弃 恢复后整条消息会重发
+
+const frameMore byte = 0x40
+
+// FragmentConfig 大消息分片设置 双方都需要设置
+type FragmentConfig struct {
+	Size           int // 每个分片包体的最大长度 需要小于IFramer和ws的包长度限制 默认64K
+	MaxMessageSize int // 拼接后消息的最大长度 每个session同时只会拼接一条消息 默认16MB
+}
+
+func (sm *Manager) initFragment(config *FragmentConfig) {
+	if config == nil {
+		return
+	}
+	fc := *config
+	if fc.Size <= 0 {
+		fc.Size = 64 * 1024
+	}
+	if fc.MaxMessageSize <= 0 {
+		fc.MaxMessageSize = 16 * 1024 * 1024
+	}
+	sm.fragment = &fc
+}
+
+// 把带帧头的包拆成多个分片 每个分片都带上原来的帧头
+func (s *Session) splitFrame(pkt []byte) [][]byte {
+	fc := s.manager.fragment
+	if fc == nil || len(pkt)-1 <= fc.Size {
+		return [][]byte{pkt}
+	}
+	head, payload := pkt[0], pkt[1:]
+	frames := make([][]byte, 0, (len(payload)+fc.Size-1)/fc.Size)
+	for len(payload) > fc.Size {
+		frames = append(frames, packFrame(head|frameMore, payload[:fc.Size]))
+		payload = payload[fc.Size:]
+	}
+	return append(frames, packFrame(head, payload))
+}
+
+// 拼接分片 消息还没有拼完时返回nil
+func (s *Session) joinFrame(link *sessionLink, head byte, payload []byte) ([]byte, error) {
+	if head&frameMore == 0 && link.fragments == nil {
+		return payload, nil
+	}
+	fc := s.manager.fragment
+	if fc == nil || len(link.fragments)+len(payload) > fc.MaxMessageSize {
+		link.fragments = nil
+		return nil, ErrMaxPacket
+	}
+	link.fragments = append(link.fragments, payload...)
+	if head&frameMore != 0 {
+		return nil, nil
+	}
+	data := link.fragments
+	link.fragments = nil
+	return data, nil
+}
@@ -0,0 +1,78 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitJoinFrame(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Fragment: &FragmentConfig{Size: 100, MaxMessageSize: 1000}})
+	s := &Session{manager: sm}
+	link := &sessionLink{}
+	data := bytes.Repeat([]byte("0123456789"), 25)
+	frames := s.splitFrame(packFrame(frameData, data))
+	if len(frames) != 3 {
+		t.Fatal("frames", len(frames))
+	}
+	var out []byte
+	for i, f := range frames {
+		if more := f[0]&frameMore != 0; more != (i < 2) {
+			t.Fatal("more flag", i)
+		}
+		got, err := s.joinFrame(link, f[0], f[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 && got != nil {
+			t.Fatal("joined early", i)
+		}
+		out = got
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("joined data mismatch")
+	}
+
+	// 拼接后超过最大长度
+	big := s.splitFrame(packFrame(frameData, make([]byte, 1200)))
+	var err error
+	for _, f := range big {
+		if _, err = s.joinFrame(link, f[0], f[1:]); err != nil {
+			break
+		}
+	}
+	if err != ErrMaxPacket || link.fragments != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFragmentSession(t *testing.T) {
+	// 包长度限制1024 超过的消息分片发送
+	cfg := func(h IMsgHandler) *Config {
+		return &Config{MsgHandler: h, Framer: NewU32Framer(binary.BigEndian, 1024), Fragment: &FragmentConfig{Size: 1000}}
+	}
+	_, addr := startServer(t, "tcp", cfg(&echoHandler{}), nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, cfg(ch), nil)
+	big := strings.Repeat("potato", 2000)
+	_ = c.Session().Send(big)
+	_ = c.Session().Send("small")
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 2 })
+	if ch.last.Load() != "small" {
+		t.Fatal(ch.last.Load())
+	}
+}
+
+func TestFragmentMaxMessageSize(t *testing.T) {
+	sh := &echoHandler{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, Fragment: &FragmentConfig{Size: 1000, MaxMessageSize: 4096}}, nil)
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: &echoHandler{client: true}, Fragment: &FragmentConfig{Size: 1000}}, nil)
+	_ = c.Session().Send(strings.Repeat("x", 8000))
+	waitFor(t, "server close", func() bool { return sh.close.Load() == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if sh.got.Load() != 0 {
+		t.Fatal("oversize msg reached handler")
+	}
+}
@@ -0,0 +1,136 @@
+package net
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// 帧头 开启心跳等功能后 每个包体(加密的话在解密后)前会加上1字节帧头 低4位为帧类型 高4位为标记 第4-5位为压缩算法 第6位为分片
+// 控制帧由框架自己处理 不会经过ICodec 也不会到达IMsgHandler
+const (
+	frameData byte = 0x00 // 业务消息 交给ICodec
+	framePing byte = 0x01 // 心跳请求 后面带8字节发送方的纳秒时间戳
+	framePong byte = 0x02 // 心跳回应 原样带回ping的时间戳
+
+	frameTypeMask byte = 0x0F
+)
+
+// HeartbeatConfig 心跳设置 设置到net.Config后 双方都会定时发送ping 并回应对方的ping
+// 对方的ping也会刷新Config.Timeout的读超时 Interval*MaxMiss需要小于Timeout 否则没等到心跳判断就先读超时了
+// 空闲的连接也会因为两次ping之间超过Timeout被断开 超过时Interval会被缩短为Timeout/(MaxMiss+1)
+type HeartbeatConfig struct {
+	Interval time.Duration // 发送ping的间隔 默认5秒
+	MaxMiss  int           // 连续多少次ping没有收到pong就断开连接 默认3次
+}
+
+// 平滑rtt的权重 和tcp一样新的采样占1/8
+const rttAlpha = 8
+
+// 需要在设置timeout之后调用
+func (sm *Manager) initHeartbeat(config *HeartbeatConfig) {
+	if config == nil {
+		return
+	}
+	hb := *config
+	if hb.Interval <= 0 {
+		hb.Interval = 5 * time.Second
+	}
+	if hb.MaxMiss <= 0 {
+		hb.MaxMiss = 3
+	}
+	timeout := time.Duration(sm.timeout) * time.Second
+	if hb.Interval*time.Duration(hb.MaxMiss) >= timeout {
+		interval := timeout / time.Duration(hb.MaxMiss+1)
+		log.Sugar.Warnf("heartbeat interval %s * max miss %d exceeds timeout %s, use interval %s", hb.Interval, hb.MaxMiss, timeout, interval)
+		hb.Interval = interval
+	}
+	sm.heartbeat = &hb
+}
+
+// RTT 平滑后的往返时间 没有开启心跳或者还没有采样时为0
+func (s *Session) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.rtt))
+}
+
+// 给包体加上帧头
+func packFrame(head byte, payload []byte) []byte {
+	pkt := make([]byte, 1+len(payload))
+	pkt[0] = head
+	copy(pkt[1:], payload)
+	return pkt
+}
+
+// 解析帧头 控制帧在这里处理掉 返回nil 业务消息返回去掉帧头的包体
+func (s *Session) unpackFrame(link *sessionLink, pkt []byte) ([]byte, error) {
+	if len(pkt) < 1 {
+		return nil, ErrMinPacket
+	}
+	head, payload := pkt[0], pkt[1:]
+	switch head & frameTypeMask {
+	case frameData:
+		payload, err := s.joinFrame(link, head, payload)
+		if err != nil || payload == nil {
+			return nil, err
+		}
+		s.onRecvData()
+		return s.unpackData(head, payload)
+	case framePing:
+		s.sendCtrl(packFrame(framePong, payload))
+		return nil, nil
+	case framePong:
+		if len(payload) < 8 {
+			return nil, ErrMinPacket
+		}
+		s.onPong(int64(binary.BigEndian.Uint64(payload)))
+		return nil, nil
+	case frameAck:
+		return nil, s.onAck(payload)
+	case frameCompress:
+		return nil, s.onCompressAccept(payload)
+	}
+	log.Sugar.Warnf("unknown frame type: %d, sesid: %d", head&frameTypeMask, s.ID())
+	return nil, nil
+}
+
+// 控制帧优先于业务消息发送 队列满了就丢弃 对方会当作丢了一次心跳
+func (s *Session) sendCtrl(pkt []byte) {
+	select {
+	case s.ctrlChan <- pkt:
+	default:
+	}
+}
+
+func (s *Session) ping() {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+	atomic.AddInt32(&s.missedPong, 1)
+	s.sendCtrl(packFrame(framePing, payload))
+}
+
+func (s *Session) onPong(sendTime int64) {
+	atomic.StoreInt32(&s.missedPong, 0)
+	sample := time.Now().UnixNano() - sendTime
+	if sample < 0 {
+		return
+	}
+	rtt := atomic.LoadInt64(&s.rtt)
+	if rtt == 0 {
+		rtt = sample
+	} else {
+		rtt += (sample - rtt) / rttAlpha
+	}
+	atomic.StoreInt64(&s.rtt, rtt)
+}
+
+// 心跳检查 在写循环中定时调用 超过容忍次数返回false
+func (s *Session) heartbeat() bool {
+	if int(atomic.LoadInt32(&s.missedPong)) >= s.manager.heartbeat.MaxMiss {
+		log.Sugar.Warnf("session heartbeat timeout, sesid: %d", s.ID())
+		return false
+	}
+	s.ping()
+	return true
+}
@@ -0,0 +1,78 @@
+package net
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatRTT(t *testing.T) {
+	cfg := func(h IMsgHandler) *Config {
+		return &Config{MsgHandler: h, Heartbeat: &HeartbeatConfig{Interval: 20 * time.Millisecond}}
+	}
+	sm, addr := startServer(t, "tcp", cfg(&echoHandler{}), nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, cfg(ch), nil)
+	waitFor(t, "client rtt", func() bool { return c.Session().RTT() > 0 })
+	waitFor(t, "server rtt", func() bool { s := firstSession(sm); return s != nil && s.RTT() > 0 })
+
+	// 心跳不会到达MsgHandler 业务消息照常收发
+	_ = c.Session().Send("hi")
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 1 })
+	time.Sleep(100 * time.Millisecond)
+	if ch.got.Load() != 1 {
+		t.Fatal("ping reached handler", ch.got.Load())
+	}
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	sh := &echoHandler{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, Heartbeat: &HeartbeatConfig{Interval: 20 * time.Millisecond, MaxMiss: 2}}, nil)
+	// 不回应pong的客户端
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "open", func() bool { return sh.open.Load() == 1 })
+	waitFor(t, "heartbeat timeout", func() bool { return sh.close.Load() == 1 })
+}
+
+func TestHeartbeatCtrlFirst(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Heartbeat: &HeartbeatConfig{Interval: time.Hour}})
+	a, b := net.Pipe()
+	defer b.Close()
+	s := sm.NewSession(a)
+	defer s.Close()
+	// 写循环启动前已经排满了业务消息 控制帧还是要先发出去
+	for i := 0; i < cap(s.sendChan); i++ {
+		_ = s.Send(i)
+	}
+	s.sendCtrl(packFrame(framePong, make([]byte, 8)))
+	s.Start()
+	_ = b.SetReadDeadline(time.Now().Add(2 * time.Second))
+	pkt, err := defaultFramer.ReadFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt[0] != framePong {
+		t.Fatalf("first frame %d", pkt[0])
+	}
+}
+
+func TestHeartbeatClampInterval(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}})
+	if sm.heartbeat != nil {
+		t.Fatal("heartbeat enabled")
+	}
+	// 默认5秒*3次小于默认的30秒超时 不用调整
+	sm = NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Heartbeat: &HeartbeatConfig{}})
+	if sm.heartbeat.Interval != 5*time.Second || sm.heartbeat.MaxMiss != 3 {
+		t.Fatal(sm.heartbeat)
+	}
+	// 10秒*3次超过了10秒的超时 缩短到10秒/4
+	sm = NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Timeout: 10, Heartbeat: &HeartbeatConfig{Interval: 10 * time.Second}})
+	if sm.heartbeat.Interval != 2500*time.Millisecond {
+		t.Fatal(sm.heartbeat.Interval)
+	}
+}
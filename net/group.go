@@ -0,0 +1,114 @@
+package net
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Group 一组session 比如房间 频道 广播时消息只编码一次 同样的数据发给组里每个session
+// session关闭后会自动从所有组中移除
+type Group struct {
+	name     string
+	manager  *Manager
+	sessions sync.Map // session id -> *Session
+	count    int32
+}
+
+// Group 获取指定名称的组 不存在则创建
+func (sm *Manager) Group(name string) *Group {
+	if g, ok := sm.groupMap.Load(name); ok {
+		return g.(*Group)
+	}
+	g, _ := sm.groupMap.LoadOrStore(name, &Group{name: name, manager: sm})
+	return g.(*Group)
+}
+
+// RemoveGroup 删除组 组里的session不受影响
+func (sm *Manager) RemoveGroup(name string) {
+	g, ok := sm.groupMap.LoadAndDelete(name)
+	if !ok {
+		return
+	}
+	g.(*Group).Range(func(s *Session) bool {
+		g.(*Group).Remove(s)
+		return true
+	})
+}
+
+// BroadcastAll 广播给所有连进来的session 连接器连到其他服务器的session不会收到
+func (sm *Manager) BroadcastAll(msg any) error {
+	b := newBroadcastData(msg)
+	sm.rangeServerSessions(b.sendTo)
+	return b.err
+}
+
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Add 加入组 已经关闭的session不会加入
+func (g *Group) Add(s *Session) {
+	if s.IsClosed() {
+		return
+	}
+	if _, loaded := g.sessions.LoadOrStore(s.ID(), s); loaded {
+		return
+	}
+	atomic.AddInt32(&g.count, 1)
+	s.groups.Store(g.name, g)
+	// 和session退出时的leaveGroups并发的话 这里再检查一次
+	select {
+	case <-s.exitChan:
+		g.Remove(s)
+	default:
+	}
+}
+
+func (g *Group) Remove(s *Session) {
+	if _, ok := g.sessions.LoadAndDelete(s.ID()); !ok {
+		return
+	}
+	atomic.AddInt32(&g.count, -1)
+	s.groups.Delete(g.name)
+}
+
+func (g *Group) Contains(s *Session) bool {
+	_, ok := g.sessions.Load(s.ID())
+	return ok
+}
+
+func (g *Group) Len() int {
+	return int(atomic.LoadInt32(&g.count))
+}
+
+// Range 遍历组内session f返回false时停止
+func (g *Group) Range(f func(s *Session) bool) {
+	g.sessions.Range(func(key, value any) bool {
+		return f(value.(*Session))
+	})
+}
+
+// Broadcast 广播给组内所有session
+func (g *Group) Broadcast(msg any) error {
+	return g.BroadcastExcept(msg, nil)
+}
+
+// BroadcastExcept 广播给组内除了except之外的session 比如把自己的操作同步给房间里的其他人
+func (g *Group) BroadcastExcept(msg any, except *Session) error {
+	b := newBroadcastData(msg)
+	g.Range(func(s *Session) bool {
+		if s != except {
+			b.sendTo(s)
+		}
+		return true
+	})
+	return b.err
+}
+
+// session关闭时退出所有组
+func (s *Session) leaveGroups() {
+	s.groups.Range(func(key, value any) bool {
+		value.(*Group).Remove(s)
+		return true
+	})
+}
@@ -0,0 +1,79 @@
+package net
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// 连上来就加入房间的服务端处理器
+type roomHandler struct {
+	echoHandler
+	sm atomic.Pointer[Manager] // 服务端启动后才设置 和分发协程并发访问
+}
+
+func (h *roomHandler) OnSessionOpen(s *Session) {
+	if sm := h.sm.Load(); sm != nil {
+		sm.Group("room").Add(s)
+	}
+	h.echoHandler.OnSessionOpen(s)
+}
+
+func TestGroupBroadcast(t *testing.T) {
+	sh := &roomHandler{}
+	sm, addr := startServer(t, "tcp", &Config{MsgHandler: sh}, nil)
+	sh.sm.Store(sm)
+	var cs []IConnector
+	var hs []*echoHandler
+	for i := 0; i < 3; i++ {
+		ch := &echoHandler{client: true}
+		_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch}, []ConnectorOption{WithReconnect(false)})
+		cs = append(cs, c)
+		hs = append(hs, ch)
+	}
+	room := sm.Group("room")
+	waitFor(t, "join", func() bool { return room.Len() == 3 })
+
+	if err := room.Broadcast("all"); err != nil {
+		t.Fatal(err)
+	}
+	var first *Session
+	room.Range(func(s *Session) bool { first = s; return false })
+	_ = room.BroadcastExcept("others", first)
+	waitFor(t, "broadcast", func() bool { return hs[0].got.Load()+hs[1].got.Load()+hs[2].got.Load() == 5 })
+
+	// 关闭的session自动退出组
+	cs[0].Stop()
+	waitFor(t, "leave", func() bool { return room.Len() == 2 })
+	sm.RemoveGroup("room")
+	if room.Len() != 0 {
+		t.Fatal("remove group", room.Len())
+	}
+}
+
+func TestBroadcastAllSkipsConnectors(t *testing.T) {
+	// 既有监听又有连接器的服务 比如网关
+	upstream := &echoHandler{client: true}
+	_, upAddr := startServer(t, "tcp", &Config{MsgHandler: upstream}, nil)
+	gw, gwAddr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{client: true}}, nil)
+	up, _ := NewConnector("tcp", upAddr)
+	gw.AddConnector(up)
+	up.Start()
+	waitFor(t, "upstream", func() bool { return up.Session() != nil })
+
+	ch := &echoHandler{client: true}
+	startClient(t, "tcp", gwAddr, &Config{MsgHandler: ch}, nil)
+	waitFor(t, "client", func() bool {
+		n := 0
+		gw.rangeServerSessions(func(*Session) { n++ })
+		return n == 1
+	})
+	if err := gw.BroadcastAll("all"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "client got", func() bool { return ch.got.Load() == 1 })
+	_ = up.Session().Send("marker")
+	waitFor(t, "upstream got", func() bool { return upstream.got.Load() > 0 })
+	if upstream.got.Load() != 1 || upstream.last.Load() != "marker" {
+		t.Fatal("broadcast reached upstream")
+	}
+}
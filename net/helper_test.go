@@ -0,0 +1,114 @@
+package net
+
+import (
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/murang/potato/pb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 测试用的处理器 服务端原样回复 客户端只计数
+type echoHandler struct {
+	client      bool
+	got         atomic.Int32
+	open, close atomic.Int32
+	last        atomic.Value
+}
+
+func (h *echoHandler) IsMsgInRoutine() bool      { return false }
+func (h *echoHandler) OnSessionOpen(s *Session)  { h.open.Add(1) }
+func (h *echoHandler) OnSessionClose(s *Session) { h.close.Add(1) }
+func (h *echoHandler) OnMsg(s *Session, msg any) {
+	h.got.Add(1)
+	h.last.Store(msg)
+	if !h.client {
+		_ = s.Send(msg)
+	}
+}
+
+// 找一个空闲的本地地址 kcp需要udp端口
+func freeAddr(t *testing.T, network string) string {
+	t.Helper()
+	if network == "kcp" {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+		return pc.LocalAddr().String()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// 等待条件成立 超时则失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 启动一个服务端 测试结束时关闭
+func startServer(t *testing.T, network string, config *Config, lopts []ListenerOption, sopts ...ServeOption) (*Manager, string) {
+	t.Helper()
+	addr := freeAddr(t, network)
+	sm := NewManagerWithConfig(config)
+	ln, err := NewListener(network, addr, lopts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.AddListener(ln, sopts...)
+	sm.Start()
+	t.Cleanup(sm.OnDestroy)
+	return sm, addr
+}
+
+// 启动一个连接器 等到连上服务器再返回
+func startClient(t *testing.T, network, addr string, config *Config, copts []ConnectorOption, sopts ...ServeOption) (*Manager, IConnector) {
+	t.Helper()
+	cm := NewManagerWithConfig(config)
+	c, err := NewConnector(network, addr, append([]ConnectorOption{WithBackoff(20*time.Millisecond, 100*time.Millisecond)}, copts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.AddConnector(c, sopts...)
+	cm.Start()
+	t.Cleanup(cm.OnDestroy)
+	waitFor(t, "connector session", func() bool { return c.Session() != nil })
+	return cm, c
+}
+
+// 服务端的第一个session
+func firstSession(sm *Manager) *Session {
+	var sess *Session
+	sm.sessionMap.Range(func(k, v any) bool {
+		sess = v.(*Session)
+		return false
+	})
+	return sess
+}
+
+var regTestOnce sync.Once
+
+// 注册测试使用的pb消息 重复注册会直接退出进程 所以只注册一次
+func regTestMsgs() {
+	regTestOnce.Do(func() {
+		pb.RegisterMsg(901, reflect.TypeOf(&wrapperspb.StringValue{}))
+		pb.RegisterMsg(902, reflect.TypeOf(&wrapperspb.Int32Value{}))
+		pb.RegisterMsgPair(903, reflect.TypeOf(&wrapperspb.UInt64Value{}), reflect.TypeOf(&wrapperspb.BoolValue{}))
+	})
+}
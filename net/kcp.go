@@ -0,0 +1,95 @@
+package net
+
+import (
+	"github.com/xtaci/kcp-go"
+)
+
+// KcpProfile kcp的nodelay参数 对应SetNoDelay的四个参数
+type KcpProfile struct {
+	NoDelay  int // 是否启用nodelay模式 0不启用 1启用
+	Interval int // 内部更新间隔 单位毫秒
+	Resend   int // 快速重传 0关闭 2表示2次ACK跨越将会直接重传
+	NC       int // 是否关闭流控 0不关闭 1关闭
+}
+
+var (
+	KcpProfileNormal = KcpProfile{0, 40, 0, 0} // 普通模式
+	KcpProfileFast   = KcpProfile{0, 30, 2, 1} // 快速模式
+	KcpProfileTurbo  = KcpProfile{1, 10, 2, 1} // 极速模式 默认
+)
+
+type KcpOption func(*kcpOptions)
+
+type kcpOptions struct {
+	profile      KcpProfile
+	streamMode   bool
+	sndWnd       int
+	rcvWnd       int
+	mtu          int
+	dataShards   int
+	parityShards int
+	dscp         int
+}
+
+func defaultKcpOptions() *kcpOptions {
+	return &kcpOptions{
+		profile:    KcpProfileTurbo,
+		streamMode: true,
+	}
+}
+
+// KcpNoDelay 设置nodelay参数 默认KcpProfileTurbo
+func KcpNoDelay(profile KcpProfile) KcpOption {
+	return func(o *kcpOptions) {
+		o.profile = profile
+	}
+}
+
+// KcpStreamMode 设置是否使用流模式 默认开启
+func KcpStreamMode(enable bool) KcpOption {
+	return func(o *kcpOptions) {
+		o.streamMode = enable
+	}
+}
+
+// KcpWindow 设置发送和接收窗口大小 单位是包 不设置使用kcp默认值
+func KcpWindow(sndWnd, rcvWnd int) KcpOption {
+	return func(o *kcpOptions) {
+		o.sndWnd = sndWnd
+		o.rcvWnd = rcvWnd
+	}
+}
+
+// KcpMtu 设置mtu 不设置使用kcp默认值
+func KcpMtu(mtu int) KcpOption {
+	return func(o *kcpOptions) {
+		o.mtu = mtu
+	}
+}
+
+// KcpFEC 设置前向纠错的数据分片和校验分片 服务端和客户端必须一致
+func KcpFEC(dataShards, parityShards int) KcpOption {
+	return func(o *kcpOptions) {
+		o.dataShards = dataShards
+		o.parityShards = parityShards
+	}
+}
+
+// KcpDSCP 设置ip包的DSCP标记
+func KcpDSCP(dscp int) KcpOption {
+	return func(o *kcpOptions) {
+		o.dscp = dscp
+	}
+}
+
+// 把参数设置到kcp会话
+func (o *kcpOptions) apply(sess *kcp.UDPSession) {
+	sess.SetNoDelay(o.profile.NoDelay, o.profile.Interval, o.profile.Resend, o.profile.NC)
+	sess.SetStreamMode(o.streamMode)
+	if o.sndWnd > 0 || o.rcvWnd > 0 {
+		sess.SetWindowSize(o.sndWnd, o.rcvWnd)
+	}
+	if o.mtu > 0 {
+		sess.SetMtu(o.mtu)
+	}
+}
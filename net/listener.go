@@ -1,8 +1,10 @@
 package net
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
+	"time"
 )
 
 type IListener interface {
@@ -11,14 +13,141 @@ type IListener interface {
 	OnNewConnection(func(net.Conn))
 }
 
-func NewListener(network, addr string) (IListener, error) {
+type ListenerOption func(*listenerOptions)
+
+type listenerOptions struct {
+	tlsConfig      *tls.Config   // tls和wss使用的证书设置
+	certFile       string        // 证书文件路径 设置后证书文件变化时会自动重新加载
+	keyFile        string        // 私钥文件路径
+	reloadInterval time.Duration // 检查证书文件变化的间隔
+	kcp            *kcpOptions
+	ws             *wsOptions
+	proxy          *proxyOptions // PROXY protocol 不设置则不解析
+	err            error         // 设置中的错误 比如错误的网段 NewListener时返回
+}
+
+func defaultListenerOptions() *listenerOptions {
+	return &listenerOptions{
+		reloadInterval: 10 * time.Second,
+		kcp:            defaultKcpOptions(),
+		ws:             defaultWsOptions(),
+	}
+}
+
+// WithTLSConfig 设置tls和wss使用的tls.Config 需要热更新证书的话可以自己设置GetCertificate
+func WithTLSConfig(config *tls.Config) ListenerOption {
+	return func(o *listenerOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithCertFile 设置tls和wss使用的证书和私钥文件 文件变化或者进程收到SIGHUP时重新加载 已建立的连接不受影响
+func WithCertFile(certFile, keyFile string) ListenerOption {
+	return func(o *listenerOptions) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// WithCertReloadInterval 设置检查证书文件变化的间隔 默认10秒 小于等于0则不检查 只在Reload时重新加载
+func WithCertReloadInterval(interval time.Duration) ListenerOption {
+	return func(o *listenerOptions) {
+		o.reloadInterval = interval
+	}
+}
+
+// WithKcp 设置kcp参数 客户端的连接器需要设置同样的FEC参数
+func WithKcp(opts ...KcpOption) ListenerOption {
+	return func(o *listenerOptions) {
+		for _, opt := range opts {
+			opt(o.kcp)
+		}
+	}
+}
+
+// WithWsPath 只接受指定路径的websocket连接 不设置则接受所有路径
+func WithWsPath(paths ...string) ListenerOption {
+	return func(o *listenerOptions) {
+		o.ws.paths = append(o.ws.paths, paths...)
+	}
+}
+
+// WithWsOrigins 只接受指定来源的websocket连接 可以是完整的Origin(https://a.com)或者域名(a.com) 不设置则接受所有来源
+func WithWsOrigins(origins ...string) ListenerOption {
+	return func(o *listenerOptions) {
+		o.ws.origins = append(o.ws.origins, origins...)
+	}
+}
+
+// WithWsSubprotocols 设置服务端支持的子协议 按照客户端请求的顺序协商
+func WithWsSubprotocols(protocols ...string) ListenerOption {
+	return func(o *listenerOptions) {
+		o.ws.subprotocols = append(o.ws.subprotocols, protocols...)
+	}
+}
+
+// WithWsTextFrame 使用文本帧 一条ws消息就是一个编码后的消息 不加IFramer的长度头 一般配合JsonCodec给网页工具使用
+// 默认使用二进制帧并且带长度头 开启心跳 加密等功能时包体不是文本 会使用二进制帧发送
+func WithWsTextFrame() ListenerOption {
+	return func(o *listenerOptions) {
+		o.ws.textFrame = true
+	}
+}
+
+// WithWsReadLimit 设置单条websocket消息的最大长度 默认1MB
+func WithWsReadLimit(limit int64) ListenerOption {
+	return func(o *listenerOptions) {
+		o.ws.readLimit = limit
+	}
+}
+
+// WithWsCompression 开启permessage-deflate压缩 需要客户端支持
+func WithWsCompression() ListenerOption {
+	return func(o *listenerOptions) {
+		o.ws.compression = true
+	}
+}
+
+// WithProxyProtocol 解析负载均衡发送的PROXY protocol头 获取客户端真实地址 tcp tls ws支持v1/v2 kcp支持v2(每个udp包前面都带头)
+// trusted为负载均衡的ip或者网段 这些地址的连接必须带头 其他地址当作直连 不设置则不解析 格式错误时NewListener返回错误
+func WithProxyProtocol(trusted ...string) ListenerOption {
+	return func(o *listenerOptions) {
+		prefixes, err := parsePrefixes(trusted)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.proxy = &proxyOptions{trusted: prefixes}
+	}
+}
+
+// WithWsTrustedProxies 信任这些代理转发的X-Forwarded-For和X-Real-IP 比如nginx或者cdn的地址 格式错误时NewListener返回错误
+func WithWsTrustedProxies(cidrs ...string) ListenerOption {
+	return func(o *listenerOptions) {
+		prefixes, err := parsePrefixes(cidrs)
+		if err != nil {
+			o.err = err
+			return
+		}
+		o.ws.trustedProxies = append(o.ws.trustedProxies, prefixes...)
+	}
+}
+
+func NewListener(network, addr string, opts ...ListenerOption) (IListener, error) {
+	o := defaultListenerOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.err != nil {
+		return nil, o.err
+	}
 	switch network {
-	case "tcp":
-		return newTcpListener(addr)
+	case "tcp", "tls":
+		return newTcpListener(network, addr, o)
 	case "kcp":
-		return newKcpListener(addr)
-	case "ws":
-		return newWsListener(addr)
+		return newKcpListener(addr, o.kcp, o.proxy)
+	case "ws", "wss":
+		return newWsListener(network, addr, o)
 	}
 	return nil, errors.New("not support network")
 }
@@ -4,37 +4,66 @@ import (
 	"github.com/murang/potato/log"
 	"github.com/xtaci/kcp-go"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
 // server
 type kcpListener struct {
 	addr            string
-	listener        net.Listener
-	exit            bool
+	listener        *kcp.Listener
+	opts            *kcpOptions
+	exit            atomic.Bool
 	onNewConnection func(net.Conn)
 }
 
-func newKcpListener(addr string) (*kcpListener, error) {
-	l, err := kcp.Listen(addr)
+func newKcpListener(addr string, opts *kcpOptions, proxy *proxyOptions) (*kcpListener, error) {
+	l, err := listenKcp(addr, opts, proxy)
 	if err != nil {
 		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
 		return nil, err
 	}
+	if opts.dscp > 0 {
+		if err = l.SetDSCP(opts.dscp); err != nil {
+			log.Sugar.Warnf("kcp set dscp error: %v", err)
+		}
+	}
 	log.Sugar.Infof("kcp listen on %s", addr)
 	s := &kcpListener{
 		addr:     addr,
 		listener: l,
+		opts:     opts,
 	}
 	return s, nil
 }
 
+// 需要解析PROXY头的话 自己监听udp 把去掉头的数据交给kcp
+func listenKcp(addr string, opts *kcpOptions, proxy *proxyOptions) (*kcp.Listener, error) {
+	if proxy == nil || len(proxy.trusted) == 0 {
+		return kcp.ListenWithOptions(addr, nil, opts.dataShards, opts.parityShards)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := kcp.ServeConn(nil, opts.dataShards, opts.parityShards, newProxyPacketConn(conn, proxy))
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
 func (s *kcpListener) Start() {
 	go s.accept()
 }
 
 func (s *kcpListener) Stop() {
-	s.exit = true
+	s.exit.Store(true)
 	err := s.listener.Close()
 	if err != nil {
 		log.Sugar.Errorf("close kcp listener error: %v", err)
@@ -54,14 +83,14 @@ func (s *kcpListener) accept() {
 				time.Sleep(time.Millisecond)
 				continue
 			}
-			if s.exit {
+			if s.exit.Load() {
 				break
 			}
 			// 调试状态时, 才打出accept的具体错误
 			log.Sugar.Errorf("kcp.accept failed: %v", err.Error())
 			break
 		} else {
-			if s.exit {
+			if s.exit.Load() {
 				break
 			}
 			if s.onNewConnection == nil {
@@ -70,9 +99,7 @@ func (s *kcpListener) accept() {
 			}
 
 			kcpConn := conn.(*kcp.UDPSession)
-			//kcpConn.SetNoDelay(0, 40, 0, 0) // normal mode
-			kcpConn.SetNoDelay(1, 10, 2, 1) // turbo mode
-			kcpConn.SetStreamMode(true)
+			s.opts.apply(kcpConn)
 
 			go s.onNewConnection(kcpConn)
 		}
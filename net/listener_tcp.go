@@ -3,27 +3,32 @@ package net
 import (
 	"github.com/murang/potato/log"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
 // server
 type tcpListener struct {
+	network         string
 	addr            string
 	listener        net.Listener
-	exit            bool
+	reloader        *certReloader
+	exit            atomic.Bool
 	onNewConnection func(net.Conn)
 }
 
-func newTcpListener(addr string) (*tcpListener, error) {
-	l, err := net.Listen("tcp", addr)
+func newTcpListener(network, addr string, opts *listenerOptions) (*tcpListener, error) {
+	l, reloader, err := listenWithOptions(network, addr, opts)
 	if err != nil {
 		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
 		return nil, err
 	}
-	log.Sugar.Infof("tcp listen on %s", addr)
+	log.Sugar.Infof("%s listen on %s", network, addr)
 	s := &tcpListener{
+		network:  network,
 		addr:     addr,
 		listener: l,
+		reloader: reloader,
 	}
 	return s, nil
 }
@@ -33,12 +38,25 @@ func (s *tcpListener) Start() {
 }
 
 func (s *tcpListener) Stop() {
-	s.exit = true
+	s.exit.Store(true)
+	if s.reloader != nil {
+		s.reloader.Stop()
+	}
 	err := s.listener.Close()
 	if err != nil {
-		log.Sugar.Errorf("close tcp listener error: %v", err)
+		log.Sugar.Errorf("close %s listener error: %v", s.network, err)
+		return
+	}
+}
+
+// Reload 重新加载证书 只有tls监听有效
+func (s *tcpListener) Reload() {
+	if s.reloader == nil {
 		return
 	}
+	if err := s.reloader.Reload(); err != nil {
+		log.Sugar.Errorf("reload tls certificate error: %v", err)
+	}
 }
 
 func (s *tcpListener) OnNewConnection(f func(net.Conn)) {
@@ -53,14 +71,14 @@ func (s *tcpListener) accept() {
 				time.Sleep(time.Millisecond)
 				continue
 			}
-			if s.exit {
+			if s.exit.Load() {
 				break
 			}
 			// 调试状态时, 才打出accept的具体错误
-			log.Sugar.Errorf("tcp.accept failed: %v", err.Error())
+			log.Sugar.Errorf("%s.accept failed: %v", s.network, err.Error())
 			break
 		} else {
-			if s.exit {
+			if s.exit.Load() {
 				break
 			}
 			if s.onNewConnection == nil {
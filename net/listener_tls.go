@@ -0,0 +1,140 @@
+package net
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+var ErrNoCertificate = errors.New("tls listener needs WithTLSConfig or WithCertFile")
+
+// 证书热加载 握手时通过GetCertificate取当前证书 所以替换证书不会影响已经建立的连接
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	modTime  time.Time
+	mu       sync.Mutex
+	exitOnce sync.Once
+	exitChan chan struct{}
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		exitChan: make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload 重新读取证书文件
+func (r *certReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	r.modTime = r.lastModTime()
+	log.Sugar.Infof("tls certificate loaded: %s", r.certFile)
+	return nil
+}
+
+func (r *certReloader) lastModTime() time.Time {
+	var t time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// 定时检查证书文件的修改时间 变化了就重新加载
+func (r *certReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.exitChan:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			changed := r.lastModTime().After(r.modTime)
+			r.mu.Unlock()
+			if !changed {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				// 证书可能还没写完 继续使用旧证书 下次再试
+				log.Sugar.Errorf("reload tls certificate error: %v", err)
+			}
+		}
+	}
+}
+
+func (r *certReloader) Stop() {
+	r.exitOnce.Do(func() {
+		close(r.exitChan)
+	})
+}
+
+// 根据设置生成tls.Config 设置了证书文件的话会返回热加载器
+func (o *listenerOptions) buildTLS() (*tls.Config, *certReloader, error) {
+	if o.tlsConfig == nil && o.certFile == "" {
+		return nil, nil, ErrNoCertificate
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.tlsConfig != nil {
+		config = o.tlsConfig.Clone()
+	}
+	if o.certFile == "" {
+		return config, nil, nil
+	}
+	reloader, err := newCertReloader(o.certFile, o.keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.Certificates = nil
+	config.GetCertificate = reloader.getCertificate
+	if o.reloadInterval > 0 {
+		go reloader.watch(o.reloadInterval)
+	}
+	return config, reloader, nil
+}
+
+// 监听地址 需要tls的话包装成tls监听
+func listenWithOptions(network, addr string, o *listenerOptions) (net.Listener, *certReloader, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	// PROXY头在tls握手之前
+	if o.proxy != nil && len(o.proxy.trusted) > 0 {
+		l = &proxyListener{Listener: l, opts: o.proxy}
+	}
+	if network != "tls" && network != "wss" {
+		return l, nil, nil
+	}
+	config, reloader, err := o.buildTLS()
+	if err != nil {
+		_ = l.Close()
+		return nil, nil, err
+	}
+	return tls.NewListener(l, config), reloader, nil
+}
@@ -0,0 +1,132 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 运行时生成自签名证书写到dir 返回证书和私钥文件路径以及证书本身
+func writeSelfSigned(t *testing.T, dir, name string) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	kb, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// 连上去看看服务端当前用的是哪张证书
+func peerCertName(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestTLSListener(t *testing.T) {
+	certFile, keyFile, cert := writeSelfSigned(t, t.TempDir(), "potato")
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	for _, network := range []string{"tls", "wss"} {
+		t.Run(network, func(t *testing.T) {
+			_, addr := startServer(t, network, &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithCertFile(certFile, keyFile)})
+			ch := &echoHandler{client: true}
+			_, c := startClient(t, network, addr, &Config{MsgHandler: ch}, []ConnectorOption{WithDialTLSConfig(&tls.Config{RootCAs: roots})})
+			for i := 0; i < 10; i++ {
+				_ = c.Session().Send(i)
+			}
+			waitFor(t, "echo", func() bool { return ch.got.Load() == 10 })
+		})
+	}
+}
+
+func TestTLSListenerNoCert(t *testing.T) {
+	for _, network := range []string{"tls", "wss"} {
+		if _, err := NewListener(network, freeAddr(t, "tcp")); !errors.Is(err, ErrNoCertificate) {
+			t.Fatal(network, err)
+		}
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeSelfSigned(t, dir, "first")
+	sm, addr := startServer(t, "tls", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithCertFile(certFile, keyFile), WithCertReloadInterval(0)})
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tls", addr, &Config{MsgHandler: ch}, []ConnectorOption{WithDialTLSConfig(&tls.Config{InsecureSkipVerify: true})})
+	if name := peerCertName(t, addr); name != "first" {
+		t.Fatal(name)
+	}
+
+	// 不检查文件变化时 只有Reload(收到SIGHUP时调用)才换证书
+	writeSelfSigned(t, dir, "second")
+	if name := peerCertName(t, addr); name != "first" {
+		t.Fatal("reloaded without signal", name)
+	}
+	sm.Reload()
+	if name := peerCertName(t, addr); name != "second" {
+		t.Fatal("not reloaded", name)
+	}
+
+	// 已经建立的连接不受影响
+	_ = c.Session().Send("still alive")
+	waitFor(t, "echo after reload", func() bool { return ch.got.Load() == 1 })
+
+	// 新证书有问题时继续使用旧证书
+	if err := os.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sm.Reload()
+	if name := peerCertName(t, addr); name != "second" {
+		t.Fatal("broken cert replaced", name)
+	}
+}
+
+func TestTLSReloadOnFileChange(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeSelfSigned(t, dir, "first")
+	_, addr := startServer(t, "wss", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithCertFile(certFile, keyFile), WithCertReloadInterval(20 * time.Millisecond)})
+	if name := peerCertName(t, addr); name != "first" {
+		t.Fatal(name)
+	}
+	writeSelfSigned(t, dir, "second")
+	// 文件系统的时间精度可能不够 把修改时间往后调一点
+	later := time.Now().Add(time.Second)
+	_ = os.Chtimes(certFile, later, later)
+	waitFor(t, "reload on file change", func() bool { return peerCertName(t, addr) == "second" })
+}
@@ -2,11 +2,18 @@ package net
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/murang/potato/log"
@@ -14,30 +21,77 @@ import (
 
 const maxWsBufferSize = 1024 * 1024 // WebSocket 单消息最大 1MB
 
+type wsOptions struct {
+	paths          []string       // 允许的路径
+	origins        []string       // 允许的来源
+	subprotocols   []string       // 支持的子协议
+	textFrame      bool           // 是否使用文本帧 一条消息就是一个包体 不加长度头
+	readLimit      int64          // 单条消息最大长度
+	compression    bool           // 是否开启permessage-deflate
+	trustedProxies []netip.Prefix // 信任X-Forwarded-For的代理
+}
+
+func defaultWsOptions() *wsOptions {
+	return &wsOptions{
+		readLimit: maxWsBufferSize,
+	}
+}
+
+func (o *wsOptions) checkOrigin(r *http.Request) bool {
+	if len(o.origins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" { // 非浏览器客户端没有Origin
+		return true
+	}
+	if slices.Contains(o.origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(o.origins, u.Host)
+}
+
+func (o *wsOptions) frameType() int {
+	if o.textFrame {
+		return websocket.TextMessage
+	}
+	return websocket.BinaryMessage
+}
+
 // server
 type wsListener struct {
+	network         string
 	addr            string
 	listener        net.Listener
+	reloader        *certReloader
+	opts            *wsOptions
 	server          *http.Server
 	upgrade         *websocket.Upgrader
-	exit            bool
+	exit            atomic.Bool
 	onNewConnection func(net.Conn)
 }
 
-func newWsListener(addr string) (*wsListener, error) {
-	l, err := net.Listen("tcp", addr)
+func newWsListener(network, addr string, opts *listenerOptions) (*wsListener, error) {
+	l, reloader, err := listenWithOptions(network, addr, opts)
 	if err != nil {
 		log.Sugar.Errorf("listen error on %s, because: %v", addr, err)
 		return nil, err
 	}
-	log.Sugar.Infof("ws listen on %s", addr)
+	log.Sugar.Infof("%s listen on %s", network, addr)
 	s := &wsListener{
+		network:  network,
 		addr:     addr,
 		listener: l,
+		reloader: reloader,
+		opts:     opts.ws,
 		upgrade: &websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+			CheckOrigin:       opts.ws.checkOrigin,
+			Subprotocols:      opts.ws.subprotocols,
+			EnableCompression: opts.ws.compression,
 		},
 	}
 	s.server = &http.Server{
@@ -49,23 +103,36 @@ func newWsListener(addr string) (*wsListener, error) {
 func (s *wsListener) Start() {
 	go func() {
 		err := s.server.Serve(s.listener)
-		if err != nil && !s.exit {
-			log.Sugar.Errorf("ws serve error:%v", err)
+		if err != nil && !s.exit.Load() {
+			log.Sugar.Errorf("%s serve error:%v", s.network, err)
 		}
 	}()
 }
 
 func (s *wsListener) Stop() {
-	s.exit = true
+	s.exit.Store(true)
+	if s.reloader != nil {
+		s.reloader.Stop()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 	err := s.server.Shutdown(ctx)
 	if err != nil {
-		log.Sugar.Errorf("close ws listener error: %v", err)
+		log.Sugar.Errorf("close %s listener error: %v", s.network, err)
 		return
 	}
 }
 
+// Reload 重新加载证书 只有wss监听有效
+func (s *wsListener) Reload() {
+	if s.reloader == nil {
+		return
+	}
+	if err := s.reloader.Reload(); err != nil {
+		log.Sugar.Errorf("reload tls certificate error: %v", err)
+	}
+}
+
 func (s *wsListener) OnNewConnection(f func(net.Conn)) {
 	s.onNewConnection = f
 }
@@ -81,20 +148,104 @@ func (s *wsListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if len(s.opts.paths) > 0 && !slices.Contains(s.opts.paths, r.URL.Path) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	conn, err := s.upgrade.Upgrade(w, r, nil)
 	if err != nil {
 		log.Sugar.Warnf("Error while upgrading connection:%v", err)
 		return
 	}
+	if s.opts.compression {
+		conn.EnableWriteCompression(true)
+	}
 
-	wc := &wsConn{Conn: conn}
+	wc := newWsConn(conn, s.opts.frameType(), s.opts.readLimit)
+	if len(s.opts.trustedProxies) > 0 {
+		wc.remoteAddr = forwardedAddr(r, s.opts.trustedProxies)
+	}
 	go s.onNewConnection(wc)
 }
 
 type wsConn struct {
 	buffer []byte
 	*websocket.Conn
-	mu sync.Mutex
+	mu         sync.Mutex
+	frameType  int      // 发送使用的帧类型
+	readLimit  int64    // 单条消息最大长度
+	remoteAddr net.Addr // 代理转发的客户端地址
+}
+
+func newWsConn(conn *websocket.Conn, frameType int, readLimit int64) *wsConn {
+	if readLimit <= 0 {
+		readLimit = maxWsBufferSize
+	}
+	conn.SetReadLimit(readLimit)
+	return &wsConn{
+		Conn:      conn,
+		frameType: frameType,
+		readLimit: readLimit,
+	}
+}
+
+// 文本帧模式 一条ws消息就是一个包体
+func (w *wsConn) textMode() bool {
+	return w.frameType == websocket.TextMessage
+}
+
+// 读一条完整的消息 协商编解码时读了一部分的话先返回剩下的
+func (w *wsConn) readMessage() ([]byte, error) {
+	if len(w.buffer) > 0 {
+		p := w.buffer
+		w.buffer = nil
+		return p, nil
+	}
+	_, p, err := w.Conn.ReadMessage()
+	return p, err
+}
+
+// 写一条完整的消息 text为false或者不是utf8文本的数据只能用二进制帧
+func (w *wsConn) writeMessage(b []byte, text bool) error {
+	frameType := w.frameType
+	if !text || !utf8.Valid(b) {
+		frameType = websocket.BinaryMessage
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Conn.WriteMessage(frameType, b)
+}
+
+var errNotWsConn = errors.New("ws message framer needs ws conn")
+
+// wsMessageFramer 文本帧的ws连接使用 直接用ws的消息边界 不加长度头 网页工具可以直接收发json
+// 有帧头或者加密时包体不是文本 text为false 全部使用二进制帧
+type wsMessageFramer struct {
+	text bool
+}
+
+func (f wsMessageFramer) ReadFrame(reader io.Reader) ([]byte, error) {
+	w, ok := reader.(*wsConn)
+	if !ok {
+		return nil, errNotWsConn
+	}
+	return w.readMessage()
+}
+
+func (f wsMessageFramer) WriteFrame(writer io.Writer, data []byte) error {
+	w, ok := writer.(*wsConn)
+	if !ok {
+		return errNotWsConn
+	}
+	return w.writeMessage(data, f.text)
+}
+
+// 连接使用的封包格式 文本帧的ws连接一条消息就是一个包体 其他使用Config.Framer
+func (sm *Manager) linkFramer(conn net.Conn) IFramer {
+	if w, ok := conn.(*wsConn); ok && w.textMode() {
+		return wsMessageFramer{text: !sm.frameHead && sm.crypto == nil}
+	}
+	return sm.framer
 }
 
 // 实现Conn接口
@@ -114,7 +265,7 @@ func (w *wsConn) Read(b []byte) (n int, err error) {
 		return
 	}
 	// 检查消息大小，防止内存耗尽
-	if len(w.buffer)+len(p) > maxWsBufferSize {
+	if int64(len(w.buffer)+len(p)) > w.readLimit {
 		return 0, fmt.Errorf("ws buffer overflow: %d bytes", len(w.buffer)+len(p))
 	}
 	// 把p放入buffer
@@ -126,10 +277,18 @@ func (w *wsConn) Read(b []byte) (n int, err error) {
 	return
 }
 
+// RemoteAddr 有代理转发的客户端地址时返回客户端地址
+func (w *wsConn) RemoteAddr() net.Addr {
+	if w.remoteAddr != nil {
+		return w.remoteAddr
+	}
+	return w.Conn.RemoteAddr()
+}
+
 func (w *wsConn) Write(b []byte) (n int, err error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	err = w.Conn.WriteMessage(websocket.BinaryMessage, b)
+	err = w.Conn.WriteMessage(w.frameType, b)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,128 @@
+package net
+
+import (
+	"encoding/binary"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialWs(t *testing.T, url string, header http.Header, protocols ...string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	dialer := &websocket.Dialer{HandshakeTimeout: time.Second, Subprotocols: protocols}
+	conn, resp, err := dialer.Dial(url, header)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func TestWsTextFrame(t *testing.T) {
+	sh := &echoHandler{}
+	_, addr := startServer(t, "ws", &Config{MsgHandler: sh, Codec: &JsonCodec{}}, []ListenerOption{WithWsTextFrame()})
+	conn, _, err := dialWs(t, "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 网页工具直接发json 不带长度头
+	if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"name":"potato"}`)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	typ, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != websocket.TextMessage || string(data) != `{"name":"potato"}` {
+		t.Fatalf("got %d %q", typ, data)
+	}
+	if sh.got.Load() != 1 {
+		t.Fatal("server got", sh.got.Load())
+	}
+}
+
+func TestWsTextFrameHeartbeat(t *testing.T) {
+	_, addr := startServer(t, "ws", &Config{MsgHandler: &echoHandler{}, Heartbeat: &HeartbeatConfig{Interval: 20 * time.Millisecond}}, []ListenerOption{WithWsTextFrame()})
+	conn, _, err := dialWs(t, "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 带时间戳的ping不是文本 使用二进制帧 一条消息就是一个帧
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	typ, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != websocket.BinaryMessage || len(data) != 9 || data[0] != framePing {
+		t.Fatalf("got %d %v", typ, data)
+	}
+}
+
+func TestWsBinaryFrame(t *testing.T) {
+	_, addr := startServer(t, "ws", &Config{MsgHandler: &echoHandler{}, Codec: &JsonCodec{}}, nil)
+	conn, _, err := dialWs(t, "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 二进制帧保持和tcp一样的长度头
+	pkt := binary.BigEndian.AppendUint32(nil, 2)
+	pkt = append(pkt, "12"...)
+	if err = conn.WriteMessage(websocket.BinaryMessage, pkt); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	typ, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != websocket.BinaryMessage || string(data) != string(pkt) {
+		t.Fatalf("got %d %q", typ, data)
+	}
+}
+
+func TestWsOptions(t *testing.T) {
+	_, addr := startServer(t, "ws", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{
+		WithWsPath("/game"), WithWsOrigins("example.com"), WithWsSubprotocols("pb", "json"), WithWsReadLimit(64),
+	})
+	if _, resp, err := dialWs(t, "ws://"+addr+"/other", nil); err == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatal("path not checked", err)
+	}
+	if _, _, err := dialWs(t, "ws://"+addr+"/game", http.Header{"Origin": {"https://evil.com"}}); err == nil {
+		t.Fatal("origin not checked")
+	}
+	conn, _, err := dialWs(t, "ws://"+addr+"/game", http.Header{"Origin": {"https://example.com"}}, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Subprotocol() != "json" {
+		t.Fatal("subprotocol", conn.Subprotocol())
+	}
+	// 超过读取上限的消息会断开连接
+	_ = conn.WriteMessage(websocket.BinaryMessage, make([]byte, 128))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err = conn.ReadMessage(); err == nil {
+		t.Fatal("read limit not applied")
+	}
+}
+
+func TestWsCompression(t *testing.T) {
+	_, addr := startServer(t, "ws", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithWsCompression()})
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "ws", addr, &Config{MsgHandler: ch}, []ConnectorOption{WithDialWsCompression()})
+	_ = c.Session().Send(string(make([]byte, 4096)))
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 1 })
+}
+
+func TestKcpOptions(t *testing.T) {
+	_, addr := startServer(t, "kcp", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{
+		WithKcp(KcpFEC(10, 3), KcpWindow(512, 512), KcpMtu(1200), KcpNoDelay(KcpProfileNormal)),
+	})
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "kcp", addr, &Config{MsgHandler: ch}, []ConnectorOption{WithDialKcp(KcpFEC(10, 3))})
+	for i := 0; i < 10; i++ {
+		_ = c.Session().Send(i)
+	}
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 10 })
+}
@@ -1,18 +1,34 @@
 package net
 
 import (
+	"github.com/asynkron/protoactor-go/actor"
 	"github.com/murang/potato/log"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Config struct {
-	SessionStartId uint64      // 会话起始id
-	ConnectLimit   int32       // 连接限制
-	Timeout        int32       // 超时 单位秒
-	Codec          ICodec      // 消息编解码
-	MsgHandler     IMsgHandler // 消息处理器
+	SessionStartId uint64                             // 会话起始id
+	ConnectLimit   int32                              // 连接限制
+	Timeout        int32                              // 超时 单位秒
+	Codec          ICodec                             // 消息编解码
+	Framer         IFramer                            // 封包格式 默认为4字节大端序长度+包体 包体最大1MB
+	Crypto         *CryptoConfig                      // 传输加密 不设置则明文传输
+	Heartbeat      *HeartbeatConfig                   // 心跳 不设置则只依靠Timeout检查连接
+	Resume         *ResumeConfig                      // 断线恢复 不设置则断线后直接关闭session
+	Compression    *CompressionConfig                 // 包体压缩 不设置则不压缩 双方都开启时才会压缩
+	Fragment       *FragmentConfig                    // 大消息分片 不设置则超过IFramer包长度限制的消息发送失败
+	RateLimit      *RateLimitConfig                   // 限流 不设置则只有ConnectLimit限制
+	SendQueue      *SendQueueConfig                   // 发送队列 不设置则长度32 满了最多阻塞5秒
+	Coalesce       *CoalesceConfig                    // 合并写 不设置则每条消息单独写出 ws/wss连接不合并
+	Bind           *BindConfig                        // 用户绑定 不设置则同一个用户再次绑定时踢掉旧的session
+	DrainNotice    func(session *Session) any         // Drain时发给每个session的最后一条消息 比如停服通知 返回nil则不发送 每个session在单独的协程中调用
+	DrainTimeout   time.Duration                      // Drain等待session关闭的最长时间 默认10秒 小于0则只看ctx
+	DispatchShards int                                // 消息分发的协程数 同一个session的消息总在同一个协程中按顺序处理 默认1
+	MsgHandler     IMsgHandler                        // 消息处理器
+	SessionActor   func(session *Session) actor.Actor // 设置后每个session生成一个actor处理消息 不再使用MsgHandler
 }
 
 func defaultConfig() *Config {
@@ -24,16 +40,41 @@ func defaultConfig() *Config {
 }
 
 type Manager struct {
-	idGen            uint64
-	sessionMap       sync.Map
-	sessionCount     int32
-	connMu           sync.Mutex
-	listeners        []IListener
-	codec            ICodec
-	connectLimit     int32
-	timeout          int32
-	sessionEventChan chan *SessionEvent
-	msgHandler       IMsgHandler
+	idGen             uint64
+	sessionMap        sync.Map
+	sessionCount      int32
+	connMu            sync.Mutex
+	listeners         []IListener
+	connectors        []IConnector
+	codec             ICodec
+	framer            IFramer
+	crypto            *CryptoConfig
+	heartbeat         *HeartbeatConfig
+	resume            *ResumeConfig
+	resumeMap         sync.Map // resume token -> *Session
+	groupMap          sync.Map // group name -> *Group
+	binding           userBinding
+	draining          atomic.Bool // Drain之后不再接受新连接
+	drainNotice       func(session *Session) any
+	drainTimeout      time.Duration
+	stopOnce          sync.Once
+	sendQueue         SendQueueConfig
+	coalesce          *CoalesceConfig
+	compression       *CompressionConfig
+	compressAccept    byte // Compression.Accept的掩码
+	zstd              zstdCoder
+	fragment          *FragmentConfig
+	rateLimit         *RateLimitConfig
+	ipLimiter         *ipLimiter
+	acl               accessControl
+	frameHead         bool // 包体是否带1字节帧头 开启心跳等功能时需要
+	directWrite       bool // 消息可以直接编码进发送缓冲区 没有帧头和断线恢复并且使用内置IFramer时
+	connectLimit      int32
+	timeout           int32
+	sessionEventChans []chan *SessionEvent // 按session id分片的事件队列
+	msgHandler        IMsgHandler
+	sessionActor      func(session *Session) actor.Actor
+	actorSystem       *actor.ActorSystem
 }
 
 func NewManager() *Manager {
@@ -43,46 +84,194 @@ func NewManager() *Manager {
 
 func NewManagerWithConfig(config *Config) *Manager {
 	m := &Manager{
-		sessionMap:       sync.Map{},
-		listeners:        make([]IListener, 0),
-		sessionEventChan: make(chan *SessionEvent, 1024),
+		sessionMap: sync.Map{},
+		listeners:  make([]IListener, 0),
+		connectors: make([]IConnector, 0),
+	}
+	shards := config.DispatchShards
+	if shards <= 0 {
+		shards = 1
+	}
+	m.sessionEventChans = make([]chan *SessionEvent, shards)
+	for i := range m.sessionEventChans {
+		m.sessionEventChans[i] = make(chan *SessionEvent, 1024)
 	}
 	m.idGen = config.SessionStartId
 	m.codec = config.Codec
-	if config.Codec == nil {
-		config.Codec = &JsonCodec{}
+	if m.codec == nil {
+		m.codec = &JsonCodec{}
 	}
-	m.connectLimit = config.ConnectLimit
-	if m.connectLimit <= 0 {
-		m.connectLimit = 50000
+	m.framer = config.Framer
+	if m.framer == nil {
+		m.framer = defaultFramer
 	}
+	m.crypto = config.Crypto
 	m.timeout = config.Timeout
 	if m.timeout <= 0 {
 		m.timeout = 30
 	}
+	m.initHeartbeat(config.Heartbeat)
+	if config.Resume != nil {
+		rc := *config.Resume
+		if rc.GraceWindow <= 0 {
+			rc.GraceWindow = 30 * time.Second
+		}
+		if rc.BufferSize <= 0 {
+			rc.BufferSize = 1024
+		}
+		m.resume = &rc
+	}
+	m.initSendQueue(config.SendQueue)
+	m.initCoalesce(config.Coalesce)
+	m.initBind(config.Bind)
+	m.drainNotice = config.DrainNotice
+	m.drainTimeout = config.DrainTimeout
+	if m.drainTimeout == 0 {
+		m.drainTimeout = defaultDrainTimeout
+	}
+	m.initCompression(config.Compression)
+	m.initFragment(config.Fragment)
+	m.initRateLimit(config.RateLimit)
+	m.frameHead = m.heartbeat != nil || m.resume != nil || m.compression != nil || m.fragment != nil
+	_, headed := m.framer.(headFramer)
+	m.directWrite = !m.frameHead && headed
+	m.connectLimit = config.ConnectLimit
+	if m.connectLimit <= 0 {
+		m.connectLimit = 50000
+	}
 	m.msgHandler = config.MsgHandler
+	m.sessionActor = config.SessionActor
 	return m
 }
 
 func (sm *Manager) OnNewConnection(conn net.Conn) {
-	if sm.connectLimit > 0 {
-		sm.connMu.Lock()
-		if sm.sessionCount >= sm.connectLimit {
-			sm.connMu.Unlock()
-			log.Sugar.Warnf("connect limit: %d", sm.connectLimit)
-			_ = conn.Close()
-			return
-		}
-		sm.sessionCount++
+	sm.serve(conn, nil)
+}
+
+func (sm *Manager) serve(conn net.Conn, so *serveOptions) {
+	if sm.draining.Load() {
+		_ = conn.Close()
+		return
+	}
+	ip := connIP(conn)
+	if reason, ok := sm.checkAccess(ip, so); !ok {
+		sm.denyConn(conn, ip, reason)
+		return
+	}
+	sm.connMu.Lock()
+	if sm.connectLimit > 0 && sm.sessionCount >= sm.connectLimit {
 		sm.connMu.Unlock()
+		log.Sugar.Warnf("connect limit: %d", sm.connectLimit)
+		_ = conn.Close()
+		return
+	}
+	sm.sessionCount++
+	sm.connMu.Unlock()
+	if !sm.acceptIP(ip) {
+		_ = conn.Close()
+		sm.releaseConn("")
+		return
+	}
+	codec, err := sm.negotiateCodec(conn, so)
+	if err != nil {
+		log.Sugar.Warnf("codec negotiation failed, ip: %s, err: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		sm.releaseConn(ip)
+		return
 	}
 	sess := sm.NewSession(conn)
+	sess.ip = ip
+	if codec != nil {
+		sess.codec = codec
+	}
+	if err = sess.handshake(); err != nil {
+		log.Sugar.Warnf("session handshake failed, ip: %s, err: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		sm.releaseConn(ip)
+		return
+	}
+	if sm.resume != nil {
+		var resumed bool
+		sess, resumed, err = sm.serverResume(sess)
+		if err != nil || resumed {
+			if err != nil {
+				log.Sugar.Warnf("session resume handshake failed, ip: %s, err: %v", conn.RemoteAddr(), err)
+				_ = conn.Close()
+			}
+			// 恢复到旧session的连接不再占用新的连接数
+			sm.releaseConn(ip)
+			return
+		}
+	}
 	sess.Start()
 }
 
-func (sm *Manager) AddListener(ln IListener) {
-	ln.OnNewConnection(sm.OnNewConnection)
+// 释放一个连接占用的连接数 ip为空表示没有占用ip的连接数
+func (sm *Manager) releaseConn(ip string) {
+	sm.connMu.Lock()
+	sm.sessionCount--
+	sm.connMu.Unlock()
+	if ip != "" {
+		sm.releaseIP(ip)
+	}
+}
+
+// AddListener 添加监听器 opts为这个监听器单独的设置 比如WithAllow限制只有内网可以连接
+func (sm *Manager) AddListener(ln IListener, opts ...ServeOption) error {
+	so := &serveOptions{}
+	for _, opt := range opts {
+		opt(so)
+	}
+	if so.err != nil {
+		log.Sugar.Errorf("add listener error: %v", so.err)
+		return so.err
+	}
+	ln.OnNewConnection(func(conn net.Conn) {
+		sm.serve(conn, so)
+	})
 	sm.listeners = append(sm.listeners, ln)
+	return nil
+}
+
+// 连接器连上服务器后生成session 不受连接数限制 prev为断线前的session 开启断线恢复时尝试恢复
+func (sm *Manager) onConnectorConnected(conn net.Conn, prev *Session, so *serveOptions) *Session {
+	sess := sm.NewSession(conn)
+	sess.isClient = true
+	if so.codec != nil {
+		sess.codec = so.codec
+	}
+	if err := sess.handshake(); err != nil {
+		log.Sugar.Warnf("connector handshake failed, addr: %s, err: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return nil
+	}
+	if sm.resume != nil {
+		var resumed bool
+		var err error
+		sess, resumed, err = sm.clientResume(sess, prev)
+		if err != nil {
+			log.Sugar.Warnf("connector resume handshake failed, addr: %s, err: %v", conn.RemoteAddr(), err)
+			_ = conn.Close()
+			return nil
+		}
+		if resumed {
+			return sess
+		}
+	}
+	sess.Start()
+	return sess
+}
+
+// AddConnector 添加连接器 opts可以通过WithCodec单独设置编解码
+func (sm *Manager) AddConnector(c IConnector, opts ...ServeOption) {
+	so := &serveOptions{}
+	for _, opt := range opts {
+		opt(so)
+	}
+	c.OnNewConnection(func(conn net.Conn, prev *Session) *Session {
+		return sm.onConnectorConnected(conn, prev, so)
+	})
+	sm.connectors = append(sm.connectors, c)
 }
 
 func (sm *Manager) SetMsgHandler(handler IMsgHandler) {
@@ -92,53 +281,54 @@ func (sm *Manager) SetMsgHandler(handler IMsgHandler) {
 func (sm *Manager) NewSession(conn net.Conn) *Session {
 	atomic.AddUint64(&sm.idGen, 1)
 	s := &Session{
-		manager:     sm,
-		id:          atomic.LoadUint64(&sm.idGen),
-		conn:        conn,
-		connGuard:   sync.RWMutex{},
-		exitSync:    sync.WaitGroup{},
-		sendChan:    make(chan any, 32),
-		sendRawChan: make(chan []byte, 32),
+		manager:      sm,
+		id:           atomic.LoadUint64(&sm.idGen),
+		link:         newSessionLink(conn, sm.linkFramer(conn)),
+		connGuard:    sync.RWMutex{},
+		exitSync:     sync.WaitGroup{},
+		sendChan:     make(chan any, sm.sendQueue.Size),
+		ctrlChan:     make(chan []byte, 8),
+		linkDownChan: make(chan *sessionLink, 1),
+		closeChan:    make(chan struct{}),
+		flushStart:   make(chan struct{}),
+		flushChan:    make(chan struct{}),
+		exitChan:     make(chan struct{}),
+		codec:        sm.codec,
+	}
+	s.limit = sm.newSessionLimit()
+	if sm.compression != nil {
+		s.compress = int32(sm.compression.Algorithm)
 	}
 	return s
 }
 
 func (sm *Manager) Start() {
+	if sm.sessionActor != nil && sm.actorSystem == nil {
+		sm.actorSystem = actor.NewActorSystem(actor.WithLoggerFactory(log.ColoredConsoleLogging))
+	}
 	for _, ln := range sm.listeners {
 		ln.Start()
 	}
-	go func() {
-		for ses := range sm.sessionEventChan {
-			switch ses.Type {
-			case SessionOpen:
-				sm.sessionMap.Store(ses.Session.ID(), ses.Session)
-				sm.connMu.Lock()
-				sm.sessionCount++
-				sm.connMu.Unlock()
-				log.Sugar.Infof("session open: %d", ses.Session.ID())
-				if sm.msgHandler != nil {
-					sm.msgHandler.OnSessionOpen(ses.Session)
-				}
-			case SessionClose:
-				sm.sessionMap.Delete(ses.Session.ID())
-				sm.connMu.Lock()
-				sm.sessionCount--
-				sm.connMu.Unlock()
-				log.Sugar.Infof("session close: %d", ses.Session.ID())
-				if sm.msgHandler != nil {
-					sm.msgHandler.OnSessionClose(ses.Session)
-				}
-			case SessionMsg:
-				if sm.msgHandler != nil {
-					sm.msgHandler.OnMsg(ses.Session, ses.Msg)
-				}
-			}
+	for _, c := range sm.connectors {
+		c.Start()
+	}
+	for _, events := range sm.sessionEventChans {
+		go sm.runDispatch(events)
+	}
+}
+
+// Reload 重新加载监听器的证书等资源 已经建立的连接不受影响
+func (sm *Manager) Reload() {
+	for _, ln := range sm.listeners {
+		if r, ok := ln.(interface{ Reload() }); ok {
+			r.Reload()
 		}
-	}()
+	}
 }
 
 func (sm *Manager) OnDestroy() {
-	for _, ln := range sm.listeners {
-		ln.Stop()
+	sm.stopListeners()
+	for _, c := range sm.connectors {
+		c.Stop()
 	}
 }
@@ -1,7 +1,7 @@
 package net
 
 type IMsgHandler interface {
-	IsMsgInRoutine() bool // 如果设置消息在携程中处理 消息将不会经过channel 而是直接由handler处理 需要注意并发
+	IsMsgInRoutine() bool // 如果设置消息在携程中处理 消息将不会经过channel 而是直接由handler处理 需要注意并发 否则按照Config.DispatchShards分片处理
 	OnSessionOpen(session *Session)
 	OnSessionClose(session *Session)
 	OnMsg(session *Session, msg any)
@@ -0,0 +1,101 @@
+package net
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// 编解码协商 同一个进程可以用pb服务游戏客户端 同时用json服务网页工具
+// 1. AddListener时通过WithCodec给监听器单独设置编解码 不设置则使用Config.Codec
+// 2. 通过WithNegotiation由客户端选择 ws优先按照握手时选中的子协议 否则客户端连接后先发送1字节的编解码id
+//    协商字节在加密握手之前 服务端不回复 id不支持时直接断开
+
+var ErrCodecNegotiation = errors.New("codec negotiation failed")
+
+// NegotiateCodec 可以协商的编解码 Id为客户端发送的握手字节 Subprotocol为ws子协议 为空则不能通过子协议选择
+type NegotiateCodec struct {
+	Id          byte
+	Subprotocol string
+	Codec       ICodec
+}
+
+// WithCodec 这个监听器或者连接器使用的编解码
+func WithCodec(codec ICodec) ServeOption {
+	return func(o *serveOptions) {
+		o.codec = codec
+	}
+}
+
+// WithNegotiation 由客户端选择编解码 ws的子协议需要同时在监听器上通过WithWsSubprotocols设置
+func WithNegotiation(codecs ...NegotiateCodec) ServeOption {
+	return func(o *serveOptions) {
+		o.negotiate = append(o.negotiate, codecs...)
+	}
+}
+
+// 新连接使用的编解码 返回nil表示使用Config.Codec
+func (sm *Manager) negotiateCodec(conn net.Conn, so *serveOptions) (ICodec, error) {
+	if so == nil {
+		return nil, nil
+	}
+	if len(so.negotiate) == 0 {
+		return so.codec, nil
+	}
+	if ws, ok := conn.(interface{ Subprotocol() string }); ok {
+		if protocol := ws.Subprotocol(); protocol != "" {
+			for _, c := range so.negotiate {
+				if c.Subprotocol == protocol {
+					return c.Codec, nil
+				}
+			}
+		}
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(time.Duration(sm.timeout) * time.Second)); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 1)
+	if _, err := io.ReadFull(conn, id); err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	for _, c := range so.negotiate {
+		if c.Id == id[0] {
+			return c.Codec, nil
+		}
+	}
+	return nil, ErrCodecNegotiation
+}
+
+// Codec 这个session使用的编解码
+func (s *Session) Codec() ICodec {
+	return s.codec
+}
+
+// 广播时同一种编解码只编码一次
+type broadcastData struct {
+	msg  any
+	data map[ICodec][]byte
+	err  error
+}
+
+func newBroadcastData(msg any) *broadcastData {
+	return &broadcastData{msg: msg, data: make(map[ICodec][]byte, 1)}
+}
+
+func (b *broadcastData) sendTo(s *Session) {
+	data, ok := b.data[s.codec]
+	if !ok {
+		var err error
+		if data, err = s.codec.Encode(b.msg); err != nil && b.err == nil {
+			b.err = err
+		}
+		b.data[s.codec] = data
+	}
+	if data != nil {
+		_ = s.SendRaw(data)
+	}
+}
@@ -0,0 +1,96 @@
+package net
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var testNegotiation = WithNegotiation(
+	NegotiateCodec{Id: 1, Subprotocol: "pb", Codec: &PbCodec{}},
+	NegotiateCodec{Id: 2, Subprotocol: "json", Codec: &PbJsonCodec{}},
+)
+
+// 客户端发送一条消息 等到收到回复
+func echoOnce(t *testing.T, c IConnector, ch *echoHandler) {
+	t.Helper()
+	_ = c.Session().Send(wrapperspb.String("potato"))
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 1 })
+	if v, ok := ch.last.Load().(*wrapperspb.StringValue); !ok || v.Value != "potato" {
+		t.Fatal(ch.last.Load())
+	}
+}
+
+func TestListenerCodec(t *testing.T) {
+	regTestMsgs()
+	sm, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, nil, WithCodec(&PbJsonCodec{}))
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch}, nil, WithCodec(&PbJsonCodec{}))
+	echoOnce(t, c, ch)
+	if _, ok := firstSession(sm).Codec().(*PbJsonCodec); !ok {
+		t.Fatal(firstSession(sm).Codec())
+	}
+}
+
+func TestNegotiation(t *testing.T) {
+	regTestMsgs()
+	t.Run("id", func(t *testing.T) {
+		sm, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, nil, testNegotiation)
+		ch := &echoHandler{client: true}
+		_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch}, []ConnectorOption{WithDialNegotiation(2)}, WithCodec(&PbJsonCodec{}))
+		echoOnce(t, c, ch)
+		if _, ok := firstSession(sm).Codec().(*PbJsonCodec); !ok {
+			t.Fatal(firstSession(sm).Codec())
+		}
+	})
+	t.Run("subprotocol", func(t *testing.T) {
+		sm, addr := startServer(t, "ws", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithWsSubprotocols("pb", "json")}, testNegotiation)
+		ch := &echoHandler{client: true}
+		_, c := startClient(t, "ws", addr, &Config{MsgHandler: ch}, []ConnectorOption{WithDialWsSubprotocols("json")}, WithCodec(&PbJsonCodec{}))
+		echoOnce(t, c, ch)
+		if _, ok := firstSession(sm).Codec().(*PbJsonCodec); !ok {
+			t.Fatal(firstSession(sm).Codec())
+		}
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		sh := &echoHandler{}
+		_, addr := startServer(t, "tcp", &Config{MsgHandler: sh}, nil, testNegotiation)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte{9})
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err = conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("connection not closed")
+		}
+		if sh.open.Load() != 0 {
+			t.Fatal("session opened")
+		}
+	})
+}
+
+func TestBroadcastMixedCodecs(t *testing.T) {
+	regTestMsgs()
+	sm, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, nil, testNegotiation)
+	pbh, jsonh := &echoHandler{client: true}, &echoHandler{client: true}
+	startClient(t, "tcp", addr, &Config{MsgHandler: pbh}, []ConnectorOption{WithDialNegotiation(1)}, WithCodec(&PbCodec{}))
+	startClient(t, "tcp", addr, &Config{MsgHandler: jsonh}, []ConnectorOption{WithDialNegotiation(2)}, WithCodec(&PbJsonCodec{}))
+	waitFor(t, "sessions", func() bool {
+		n := 0
+		sm.rangeServerSessions(func(*Session) { n++ })
+		return n == 2
+	})
+	if err := sm.BroadcastAll(wrapperspb.String("all")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "broadcast", func() bool { return pbh.got.Load() == 1 && jsonh.got.Load() == 1 })
+	for _, h := range []*echoHandler{pbh, jsonh} {
+		if v, ok := h.last.Load().(*wrapperspb.StringValue); !ok || v.Value != "all" {
+			t.Fatal(h.last.Load())
+		}
+	}
+}
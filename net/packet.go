@@ -8,7 +8,6 @@ import (
 )
 
 const (
-	lenSize     = 4           // 包体大小字段 数值为后续包体总共长度
 	maxPackSize = 1024 * 1024 //消息最大长度
 )
 
@@ -17,9 +16,23 @@ var (
 	ErrMinPacket = errors.New("packet short size")
 )
 
+// IFramer 封包格式 负责从字节流中切分出一个个包体
+type IFramer interface {
+	ReadFrame(reader io.Reader) ([]byte, error)
+	WriteFrame(writer io.Writer, data []byte) error
+}
+
+// IMaxPacketSize 有包体长度限制的IFramer可以实现这个接口 发送前按照它检查 超长的消息直接丢弃
+// 不实现的话发送前不检查 超长时WriteFrame返回的错误会断开连接
+type IMaxPacketSize interface {
+	MaxPacketSize() int
+}
+
+var defaultFramer = NewU32Framer(binary.BigEndian, maxPackSize)
+
 var sizeBufferPool = sync.Pool{
 	New: func() any {
-		b := make([]byte, lenSize)
+		b := make([]byte, binary.MaxVarintLen32)
 		return &b
 	},
 }
@@ -31,12 +44,32 @@ var pktBufferPool = sync.Pool{
 	},
 }
 
-// 接收Length-Value格式的封包流程 返回包中的Value
-func ReadPacket(reader io.Reader) (v []byte, err error) {
+// LengthFramer 【包体长度 + 包体】 长度字段为固定2字节或4字节
+type LengthFramer struct {
+	lenSize int
+	order   binary.ByteOrder
+	maxSize int
+}
+
+// NewU16Framer 2字节长度 包体最大65535
+func NewU16Framer(order binary.ByteOrder, maxSize int) *LengthFramer {
+	if maxSize <= 0 || maxSize > 0xFFFF {
+		maxSize = 0xFFFF
+	}
+	return &LengthFramer{lenSize: 2, order: order, maxSize: maxSize}
+}
 
-	// Size为uint32，占4字节
+// NewU32Framer 4字节长度 默认的封包格式为大端序 最大1MB
+func NewU32Framer(order binary.ByteOrder, maxSize int) *LengthFramer {
+	if maxSize <= 0 {
+		maxSize = maxPackSize
+	}
+	return &LengthFramer{lenSize: 4, order: order, maxSize: maxSize}
+}
+
+func (f *LengthFramer) ReadFrame(reader io.Reader) (v []byte, err error) {
 	bp := sizeBufferPool.Get().(*[]byte)
-	sizeBuffer := *bp
+	sizeBuffer := (*bp)[:f.lenSize]
 
 	// 持续读取Size直到读到为止
 	_, err = io.ReadFull(reader, sizeBuffer)
@@ -47,16 +80,20 @@ func ReadPacket(reader io.Reader) (v []byte, err error) {
 		return
 	}
 
-	// 用大端格式读取Size
-	bodyLen := binary.BigEndian.Uint32(sizeBuffer)
+	var bodyLen int
+	if f.lenSize == 2 {
+		bodyLen = int(f.order.Uint16(sizeBuffer))
+	} else {
+		bodyLen = int(f.order.Uint32(sizeBuffer))
+	}
 	sizeBufferPool.Put(bp)
 
-	if int(bodyLen) > maxPackSize {
+	if bodyLen > f.maxSize || bodyLen < 0 {
 		return nil, ErrMaxPacket
 	}
 
-	// 分配包体大小
-	v = make([]byte, bodyLen)
+	// 从缓冲池分配包体大小
+	v = getBuffer(bodyLen)
 
 	// 读取包体数据
 	_, err = io.ReadFull(reader, v)
@@ -64,9 +101,109 @@ func ReadPacket(reader io.Reader) (v []byte, err error) {
 	return
 }
 
-// 发送Length-Value格式的封包
-func WritePacket(writer io.Writer, msgData []byte) error {
-	totalLen := lenSize + len(msgData)
+func (f *LengthFramer) WriteFrame(writer io.Writer, msgData []byte) error {
+	if len(msgData) > f.maxSize {
+		return ErrMaxPacket
+	}
+	return writeFrame(writer, f.lenSize, msgData, func(head []byte) {
+		f.putHead(head, len(msgData))
+	})
+}
+
+func (f *LengthFramer) headLen(int) int {
+	return f.lenSize
+}
+
+func (f *LengthFramer) putHead(head []byte, bodyLen int) {
+	if f.lenSize == 2 {
+		f.order.PutUint16(head, uint16(bodyLen))
+	} else {
+		f.order.PutUint32(head, uint32(bodyLen))
+	}
+}
+
+// MaxPacketSize 包体的最大长度
+func (f *LengthFramer) MaxPacketSize() int {
+	return f.maxSize
+}
+
+// VarintFramer 【varint包体长度 + 包体】 长度字段为protobuf同款的无符号varint
+type VarintFramer struct {
+	maxSize int
+}
+
+func NewVarintFramer(maxSize int) *VarintFramer {
+	if maxSize <= 0 {
+		maxSize = maxPackSize
+	}
+	return &VarintFramer{maxSize: maxSize}
+}
+
+func (f *VarintFramer) ReadFrame(reader io.Reader) (v []byte, err error) {
+	bp := sizeBufferPool.Get().(*[]byte)
+	b := (*bp)[:1]
+
+	// 每次读取1字节 直到最高位为0
+	var bodyLen uint64
+	for i := 0; ; i++ {
+		if i >= binary.MaxVarintLen32 {
+			sizeBufferPool.Put(bp)
+			return nil, ErrMaxPacket
+		}
+		if _, err = io.ReadFull(reader, b); err != nil {
+			sizeBufferPool.Put(bp)
+			return
+		}
+		bodyLen |= uint64(b[0]&0x7F) << (7 * i)
+		if b[0] < 0x80 {
+			break
+		}
+	}
+	sizeBufferPool.Put(bp)
+
+	if bodyLen > uint64(f.maxSize) {
+		return nil, ErrMaxPacket
+	}
+
+	v = getBuffer(int(bodyLen))
+	_, err = io.ReadFull(reader, v)
+	return
+}
+
+func (f *VarintFramer) WriteFrame(writer io.Writer, msgData []byte) error {
+	if len(msgData) > f.maxSize {
+		return ErrMaxPacket
+	}
+	return writeFrame(writer, f.headLen(len(msgData)), msgData, func(head []byte) {
+		f.putHead(head, len(msgData))
+	})
+}
+
+func (f *VarintFramer) headLen(bodyLen int) int {
+	return uvarintSize(uint64(bodyLen))
+}
+
+func (f *VarintFramer) putHead(head []byte, bodyLen int) {
+	binary.PutUvarint(head, uint64(bodyLen))
+}
+
+// MaxPacketSize 包体的最大长度
+func (f *VarintFramer) MaxPacketSize() int {
+	return f.maxSize
+}
+
+func uvarintSize(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// 把长度头和包体拼到一起一次写出
+func writeFrame(writer io.Writer, headLen int, msgData []byte, putHead func(head []byte)) error {
+	totalLen := headLen + len(msgData)
 
 	bp := pktBufferPool.Get().(*[]byte)
 	pkt := *bp
@@ -78,24 +215,37 @@ func WritePacket(writer io.Writer, msgData []byte) error {
 	}
 
 	// Length
-	binary.BigEndian.PutUint32(pkt, uint32(len(msgData)))
+	putHead(pkt[:headLen])
 
 	// Value
-	copy(pkt[lenSize:], msgData)
-
-	// 将数据写入Socket
-	var err error
-	for pos := 0; pos < totalLen; {
-		n, werr := writer.Write(pkt[pos:])
-		if werr != nil {
-			err = werr
-			break
-		}
-		pos += n
-	}
+	copy(pkt[headLen:], msgData)
+
+	err := writeAll(writer, pkt)
 
 	*bp = pkt
 	pktBufferPool.Put(bp)
 
 	return err
 }
+
+// 将数据写入Socket
+func writeAll(writer io.Writer, pkt []byte) error {
+	for pos := 0; pos < len(pkt); {
+		n, err := writer.Write(pkt[pos:])
+		if err != nil {
+			return err
+		}
+		pos += n
+	}
+	return nil
+}
+
+// 接收Length-Value格式的封包流程 返回包中的Value 长度为4字节大端序 用完可以通过ReleasePacket放回缓冲池
+func ReadPacket(reader io.Reader) (v []byte, err error) {
+	return defaultFramer.ReadFrame(reader)
+}
+
+// 发送Length-Value格式的封包 长度为4字节大端序
+func WritePacket(writer io.Writer, msgData []byte) error {
+	return defaultFramer.WriteFrame(writer, msgData)
+}
@@ -0,0 +1,68 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestFramerRoundTrip(t *testing.T) {
+	framers := map[string]IFramer{
+		"u32":    defaultFramer,
+		"u16":    NewU16Framer(binary.LittleEndian, 0),
+		"varint": NewVarintFramer(0),
+	}
+	for name, f := range framers {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			for _, n := range []int{0, 1, 127, 128, 300, 20000, 65535} {
+				data := bytes.Repeat([]byte{7}, n)
+				if err := f.WriteFrame(&buf, data); err != nil {
+					t.Fatal(n, err)
+				}
+				got, err := f.ReadFrame(&buf)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatal(n, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFramerMaxSize(t *testing.T) {
+	for name, f := range map[string][2]IFramer{
+		"u32":    {NewU32Framer(binary.BigEndian, 100), NewU32Framer(binary.BigEndian, 0)},
+		"varint": {NewVarintFramer(100), NewVarintFramer(0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			limited, unlimited := f[0], f[1]
+			var buf bytes.Buffer
+			if err := limited.WriteFrame(&buf, make([]byte, 101)); !errors.Is(err, ErrMaxPacket) {
+				t.Fatal("write", err)
+			}
+			// 对方发来的超长包也要拒绝
+			_ = unlimited.WriteFrame(&buf, make([]byte, 101))
+			if _, err := limited.ReadFrame(&buf); !errors.Is(err, ErrMaxPacket) {
+				t.Fatal("read", err)
+			}
+		})
+	}
+}
+
+func TestFramerSession(t *testing.T) {
+	for name, f := range map[string]IFramer{
+		"u16":    NewU16Framer(binary.LittleEndian, 0),
+		"varint": NewVarintFramer(0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}, Framer: f}, nil)
+			ch := &echoHandler{client: true}
+			_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch, Framer: f}, nil)
+			for i := 0; i < 10; i++ {
+				_ = c.Session().Send(i)
+			}
+			waitFor(t, "echo", func() bool { return ch.got.Load() == 10 })
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PROXY protocol 负载均衡在连接开始时发送的头 带有客户端真实地址 支持v1(文本)和v2(二进制)
+// 只解析受信任的负载均衡发来的头 受信任的地址必须发送头 其他地址当作直连处理 没有受信任的地址时不解析
+// 头在第一次Read或者RemoteAddr时解析 不会阻塞accept kcp每个udp包前面都带v2头 收包时去掉
+
+var ErrProxyHeader = errors.New("invalid proxy protocol header")
+
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV1MaxLen      = 107
+	proxyV2HeadLen     = 16
+	proxyHeaderTimeout = 5 * time.Second
+)
+
+type proxyOptions struct {
+	trusted []netip.Prefix // 受信任的负载均衡地址 为空时不解析
+}
+
+func (o *proxyOptions) isTrusted(addr net.Addr) bool {
+	ip, err := netip.ParseAddr(hostOf(addr.String()))
+	return err == nil && containsAddr(o.trusted, ip.Unmap())
+}
+
+func (o *proxyOptions) wrap(conn net.Conn) net.Conn {
+	if o == nil || !o.isTrusted(conn.RemoteAddr()) {
+		return conn
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+type proxyListener struct {
+	net.Listener
+	opts *proxyOptions
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.opts.wrap(conn), nil
+}
+
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.reader)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			_ = c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr 客户端的真实地址 头中没有地址(LOCAL UNKNOWN)时为负载均衡的地址
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case 'P':
+		return readProxyV1(r)
+	case proxyV2Sig[0]:
+		return readProxyV2(r)
+	}
+	return nil, ErrProxyHeader
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrProxyHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, ErrProxyHeader
+		}
+		ip, err := netip.ParseAddr(fields[2])
+		if err != nil {
+			return nil, ErrProxyHeader
+		}
+		port, err := strconv.ParseUint(fields[4], 10, 16)
+		if err != nil {
+			return nil, ErrProxyHeader
+		}
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+	}
+	return nil, ErrProxyHeader
+}
+
+// 【签名(12字节) + 版本和命令(1字节) + 地址族和协议(1字节) + 长度(2字节) + 地址 + TLV】
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	head := make([]byte, proxyV2HeadLen)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head[:12], proxyV2Sig) || head[12]>>4 != 2 {
+		return nil, ErrProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return proxyV2Addr(head, body)
+}
+
+// udp包前面的v2头 返回地址和头的长度
+func parseProxyV2Packet(b []byte) (net.Addr, int, error) {
+	if len(b) < proxyV2HeadLen || !bytes.Equal(b[:12], proxyV2Sig) || b[12]>>4 != 2 {
+		return nil, 0, ErrProxyHeader
+	}
+	n := proxyV2HeadLen + int(binary.BigEndian.Uint16(b[14:]))
+	if len(b) < n {
+		return nil, 0, ErrProxyHeader
+	}
+	addr, err := proxyV2Addr(b[:proxyV2HeadLen], b[proxyV2HeadLen:n])
+	return addr, n, err
+}
+
+func proxyV2Addr(head, body []byte) (net.Addr, error) {
+	// LOCAL命令是负载均衡自己的健康检查等 使用原来的地址
+	if head[12]&0x0F == 0 {
+		return nil, nil
+	}
+	var ip netip.Addr
+	var port uint16
+	switch head[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, ErrProxyHeader
+		}
+		ip = netip.AddrFrom4([4]byte(body[:4]))
+		port = binary.BigEndian.Uint16(body[8:])
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, ErrProxyHeader
+		}
+		ip = netip.AddrFrom16([16]byte(body[:16])).Unmap()
+		port = binary.BigEndian.Uint16(body[32:])
+	default: // AF_UNSPEC AF_UNIX
+		return nil, nil
+	}
+	if head[13]&0x0F == 2 { // DGRAM
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+}
+
+// kcp使用的udp连接 受信任地址发来的每个udp包前面都有v2头 去掉头后交给kcp
+// 远端地址换成客户端的真实地址 kcp按照它区分session 回复时还是发给负载均衡
+type proxyPacketConn struct {
+	*net.UDPConn
+	opts *proxyOptions
+	buf  []byte // 只有kcp监听器的读协程使用
+}
+
+// 带着负载均衡地址的客户端地址
+type proxyUDPAddr struct {
+	net.Addr
+	via net.Addr
+}
+
+func newProxyPacketConn(conn *net.UDPConn, opts *proxyOptions) *proxyPacketConn {
+	return &proxyPacketConn{UDPConn: conn, opts: opts, buf: make([]byte, 64*1024)}
+}
+
+func (c *proxyPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, from, err := c.UDPConn.ReadFrom(c.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !c.opts.isTrusted(from) {
+			return copy(b, c.buf[:n]), from, nil
+		}
+		addr, headLen, err := parseProxyV2Packet(c.buf[:n])
+		if err != nil {
+			// 受信任的地址必须带头 没有的直接丢弃
+			continue
+		}
+		// LOCAL命令是负载均衡自己的健康检查等 使用原来的地址
+		if addr != nil {
+			from = &proxyUDPAddr{Addr: addr, via: from}
+		}
+		return copy(b, c.buf[headLen:n]), from, nil
+	}
+}
+
+func (c *proxyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if pa, ok := addr.(*proxyUDPAddr); ok {
+		addr = pa.via
+	}
+	return c.UDPConn.WriteTo(b, addr)
+}
+
+// 从受信任代理的X-Forwarded-For或者X-Real-IP中取客户端的ip 没有的话返回nil
+// X-Forwarded-For从右往左取第一个不受信任的地址 防止客户端自己伪造
+func forwardedAddr(r *http.Request, trusted []netip.Prefix) net.Addr {
+	peer, err := netip.ParseAddr(hostOf(r.RemoteAddr))
+	if err != nil || !containsAddr(trusted, peer.Unmap()) {
+		return nil
+	}
+	var client netip.Addr
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		ips := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(strings.TrimSpace(ips[i]))
+			if err != nil {
+				break
+			}
+			client = ip.Unmap()
+			if !containsAddr(trusted, client) {
+				break
+			}
+		}
+	}
+	if !client.IsValid() {
+		ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+		if err != nil {
+			return nil
+		}
+		client = ip.Unmap()
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(client, 0))
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// RemoteIP 客户端的真实ip 开启PROXY protocol或者ws信任代理时为代理转发的地址
+func (s *Session) RemoteIP() string {
+	return connIP(s.Conn())
+}
@@ -0,0 +1,251 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(cmd byte, ip [4]byte, port uint16) []byte {
+	var b bytes.Buffer
+	b.Write(proxyV2Sig)
+	b.WriteByte(0x20 | cmd)
+	b.WriteByte(0x11) // AF_INET STREAM
+	_ = binary.Write(&b, binary.BigEndian, uint16(12))
+	b.Write(ip[:])
+	b.Write([]byte{10, 0, 0, 1})
+	_ = binary.Write(&b, binary.BigEndian, port)
+	_ = binary.Write(&b, binary.BigEndian, uint16(443))
+	return b.Bytes()
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	cases := []struct {
+		header string
+		addr   string
+		err    error
+	}{
+		{"PROXY TCP4 1.2.3.4 10.0.0.1 5678 443\r\n", "1.2.3.4:5678", nil},
+		{"PROXY TCP6 2001:db8::1 ::1 5678 443\r\n", "[2001:db8::1]:5678", nil},
+		{"PROXY UNKNOWN\r\n", "", nil},
+		{"PROXY TCP4 1.2.3.4 10.0.0.1 5678\r\n", "", ErrProxyHeader},
+		{"PROXY TCP4 1.2.3.4 10.0.0.1 5678 443\n", "", ErrProxyHeader},
+		{"GET / HTTP/1.1\r\n", "", ErrProxyHeader},
+		{string(proxyV2Header(1, [4]byte{1, 2, 3, 4}, 5678)), "1.2.3.4:5678", nil},
+		{string(proxyV2Header(0, [4]byte{1, 2, 3, 4}, 5678)), "", nil},
+	}
+	for _, c := range cases {
+		r := bufio.NewReader(strings.NewReader(c.header + "payload"))
+		addr, err := readProxyHeader(r)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%q: %v", c.header, err)
+		}
+		if err != nil {
+			continue
+		}
+		if (addr == nil && c.addr != "") || (addr != nil && addr.String() != c.addr) {
+			t.Fatalf("%q: %v", c.header, addr)
+		}
+		// 头后面的数据不受影响
+		if rest, _ := r.ReadString(0); rest != "payload" {
+			t.Fatalf("%q: %q", c.header, rest)
+		}
+	}
+}
+
+// 直接连接服务器 先发送header 等到服务端建立session
+func dialProxy(t *testing.T, sm *Manager, addr string, header []byte) *Session {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	if header != nil {
+		if _, err = conn.Write(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "session", func() bool { return firstSession(sm) != nil })
+	return firstSession(sm)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	t.Run("v1", func(t *testing.T) {
+		sm, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithProxyProtocol("127.0.0.1")})
+		s := dialProxy(t, sm, addr, []byte("PROXY TCP4 1.2.3.4 10.0.0.1 5678 443\r\n"))
+		if s.RemoteIP() != "1.2.3.4" {
+			t.Fatal(s.RemoteIP())
+		}
+	})
+	t.Run("v2", func(t *testing.T) {
+		sm, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithProxyProtocol("127.0.0.0/8")})
+		s := dialProxy(t, sm, addr, proxyV2Header(1, [4]byte{5, 6, 7, 8}, 1000))
+		if s.RemoteIP() != "5.6.7.8" {
+			t.Fatal(s.RemoteIP())
+		}
+	})
+	t.Run("untrusted direct", func(t *testing.T) {
+		// 不是负载均衡的地址 当作直连 不解析头
+		sm, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithProxyProtocol("10.0.0.0/8")})
+		s := dialProxy(t, sm, addr, nil)
+		if s.RemoteIP() != "127.0.0.1" {
+			t.Fatal(s.RemoteIP())
+		}
+	})
+	t.Run("no trusted", func(t *testing.T) {
+		// 没有受信任的地址时不解析 不带头的连接正常收发
+		_, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, []ListenerOption{WithProxyProtocol()})
+		ch := &echoHandler{client: true}
+		_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch}, nil)
+		_ = c.Session().Send("hi")
+		waitFor(t, "echo", func() bool { return ch.got.Load() == 1 })
+	})
+	t.Run("trusted without header", func(t *testing.T) {
+		sh := &echoHandler{}
+		_, addr := startServer(t, "tcp", &Config{MsgHandler: sh}, []ListenerOption{WithProxyProtocol("127.0.0.1")})
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = defaultFramer.WriteFrame(conn, []byte(`"hi"`))
+		waitFor(t, "close", func() bool { return sh.close.Load() == 1 })
+		if sh.got.Load() != 0 {
+			t.Fatal("msg without header handled")
+		}
+	})
+}
+
+func TestParseProxyV2Packet(t *testing.T) {
+	h := proxyV2Header(1, [4]byte{1, 2, 3, 4}, 5678)
+	h[13] = 0x12 // AF_INET DGRAM
+	addr, n, err := parseProxyV2Packet(append(h, "payload"...))
+	if err != nil || n != len(h) || addr.String() != "1.2.3.4:5678" {
+		t.Fatal(addr, n, err)
+	}
+	if _, ok := addr.(*net.UDPAddr); !ok {
+		t.Fatal("not udp addr")
+	}
+	for _, b := range [][]byte{h[:10], h[:20], []byte("PROXY TCP4 1.2.3.4 10.0.0.1 5678 443\r\n")} {
+		if _, _, err = parseProxyV2Packet(b); !errors.Is(err, ErrProxyHeader) {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 模拟udp负载均衡 客户端发来的每个包前面加上v2头转发给服务器 服务器的回复原样转回客户端
+func udpProxy(t *testing.T, server string, header []byte) string {
+	t.Helper()
+	front, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saddr, _ := net.ResolveUDPAddr("udp", server)
+	back, err := net.DialUDP("udp", nil, saddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { front.Close(); back.Close() })
+	var client atomic.Pointer[net.UDPAddr]
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := front.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			client.Store(from)
+			_, _ = back.Write(append(append([]byte(nil), header...), buf[:n]...))
+		}
+	}()
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := back.Read(buf)
+			if err != nil {
+				return
+			}
+			if c := client.Load(); c != nil {
+				_, _ = front.WriteToUDP(buf[:n], c)
+			}
+		}
+	}()
+	return front.LocalAddr().String()
+}
+
+func TestProxyProtocolKcp(t *testing.T) {
+	h := proxyV2Header(1, [4]byte{9, 8, 7, 6}, 4321)
+	h[13] = 0x12
+	sh := &echoHandler{}
+	sm, addr := startServer(t, "kcp", &Config{MsgHandler: sh}, []ListenerOption{WithProxyProtocol("127.0.0.1")})
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "kcp", udpProxy(t, addr, h), &Config{MsgHandler: ch}, nil)
+	_ = c.Session().Send("hi")
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 1 })
+	if ip := firstSession(sm).RemoteIP(); ip != "9.8.7.6" {
+		t.Fatal(ip)
+	}
+
+	// 受信任的地址发来不带头的包直接丢弃
+	_, c = startClient(t, "kcp", addr, &Config{MsgHandler: &echoHandler{client: true}}, nil)
+	_ = c.Session().Send("hi")
+	time.Sleep(200 * time.Millisecond)
+	if sh.open.Load() != 1 || sh.got.Load() != 1 {
+		t.Fatal("datagram without header accepted", sh.open.Load(), sh.got.Load())
+	}
+}
+
+func TestProxyOptionError(t *testing.T) {
+	if _, err := NewListener("tcp", freeAddr(t, "tcp"), WithProxyProtocol("10.0.0.0/33")); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+	if _, err := NewListener("ws", freeAddr(t, "tcp"), WithWsTrustedProxies("nginx")); err == nil {
+		t.Fatal("invalid cidr accepted")
+	}
+}
+
+func TestForwardedAddr(t *testing.T) {
+	trusted, _ := parsePrefixes([]string{"10.0.0.0/8"})
+	cases := []struct {
+		remote string
+		xff    string
+		realIP string
+		want   string
+	}{
+		{"10.0.0.1:1000", "1.1.1.1", "", "1.1.1.1"},
+		// 客户端伪造的地址在左边 取最右边不受信任的地址
+		{"10.0.0.1:1000", "9.9.9.9, 1.1.1.1, 10.0.0.2", "", "1.1.1.1"},
+		{"10.0.0.1:1000", "", "2.2.2.2", "2.2.2.2"},
+		// 不受信任的代理发来的头不用
+		{"3.3.3.3:1000", "1.1.1.1", "2.2.2.2", ""},
+		{"10.0.0.1:1000", "", "", ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		addr := forwardedAddr(r, trusted)
+		got := ""
+		if addr != nil {
+			ip, _ := netip.ParseAddr(hostOf(addr.String()))
+			got = ip.String()
+		}
+		if got != c.want {
+			t.Fatalf("%+v: %s", c, got)
+		}
+	}
+}
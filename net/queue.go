@@ -0,0 +1,146 @@
+package net
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// OverflowPolicy 发送队列满了之后的处理方式
+type OverflowPolicy int32
+
+const (
+	OverflowBlock      OverflowPolicy = iota // 阻塞等待 超过BlockTimeout返回ErrSendTimeout
+	OverflowDropNewest                       // 丢弃正在发送的消息 返回ErrSendQueueFull
+	OverflowDropOldest                       // 丢弃队列里最早的消息 腾出位置放入新消息
+	OverflowDisconnect                       // 断开消费太慢的session 返回ErrSendQueueFull
+)
+
+var (
+	ErrSessionClosed = errors.New("session closed")
+	ErrSendQueueFull = errors.New("session send queue full")
+	ErrSendTimeout   = errors.New("session send timeout")
+)
+
+// SendQueueConfig 每个session发送队列的设置
+// 发送队列满说明对方消费太慢 默认阻塞最多5秒 避免一个慢客户端卡住整个消息分发
+type SendQueueConfig struct {
+	Size         int            // 队列长度 默认32
+	Policy       OverflowPolicy // 队列满了之后的处理方式 默认OverflowBlock
+	BlockTimeout time.Duration  // OverflowBlock时最长的等待时间 默认5秒 小于0为一直等待
+}
+
+// SendStats 发送队列的统计
+type SendStats struct {
+	QueueLen int    // 当前队列中的消息数
+	QueueCap int    // 队列长度
+	Peak     int    // 队列中消息数的最大值
+	Sent     uint64 // 已经写出的消息数
+	Dropped  uint64 // 因为队列满了丢弃的消息数
+}
+
+// 已经编码好的数据 和普通消息共用一个队列 保证发送顺序
+type rawData []byte
+
+func (sm *Manager) initSendQueue(config *SendQueueConfig) {
+	if config != nil {
+		sm.sendQueue = *config
+	}
+	if sm.sendQueue.Size <= 0 {
+		sm.sendQueue.Size = 32
+	}
+	if sm.sendQueue.BlockTimeout == 0 {
+		sm.sendQueue.BlockTimeout = 5 * time.Second
+	}
+}
+
+// SendStats 发送队列的统计
+func (s *Session) SendStats() SendStats {
+	return SendStats{
+		QueueLen: len(s.sendChan),
+		QueueCap: cap(s.sendChan),
+		Peak:     int(atomic.LoadInt32(&s.sendPeak)),
+		Sent:     atomic.LoadUint64(&s.sendCount),
+		Dropped:  atomic.LoadUint64(&s.dropCount),
+	}
+}
+
+// 放入发送队列 队列满了按照设置的策略处理
+// 和CloseAfterFlush互斥 开始flush之后的消息都会被拒绝 关闭原因一定是最后一条
+func (s *Session) enqueue(item any) error {
+	s.sendGuard.RLock()
+	defer s.sendGuard.RUnlock()
+	if s.IsClosed() || atomic.LoadInt32(&s.flushing) != 0 {
+		return ErrSessionClosed
+	}
+	return s.queue(item, s.flushStart)
+}
+
+// abort关闭时放弃阻塞等待 返回ErrSessionClosed
+func (s *Session) queue(item any, abort <-chan struct{}) error {
+	select {
+	case s.sendChan <- item:
+		s.updatePeak()
+		return nil
+	default:
+	}
+
+	switch s.manager.sendQueue.Policy {
+	case OverflowDropNewest:
+		atomic.AddUint64(&s.dropCount, 1)
+		return ErrSendQueueFull
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.sendChan <- item:
+				s.updatePeak()
+				return nil
+			default:
+			}
+			select {
+			case <-s.sendChan:
+				atomic.AddUint64(&s.dropCount, 1)
+			default:
+			}
+			if s.IsClosed() {
+				return ErrSessionClosed
+			}
+		}
+	case OverflowDisconnect:
+		atomic.AddUint64(&s.dropCount, 1)
+		log.Sugar.Warnf("session send queue full, disconnect, sesid: %d", s.ID())
+		s.Close()
+		return ErrSendQueueFull
+	}
+
+	var timeout <-chan time.Time
+	if s.manager.sendQueue.BlockTimeout > 0 {
+		timer := time.NewTimer(s.manager.sendQueue.BlockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case s.sendChan <- item:
+		s.updatePeak()
+		return nil
+	case <-s.closeChan:
+		return ErrSessionClosed
+	case <-abort:
+		return ErrSessionClosed
+	case <-timeout:
+		atomic.AddUint64(&s.dropCount, 1)
+		return ErrSendTimeout
+	}
+}
+
+func (s *Session) updatePeak() {
+	n := int32(len(s.sendChan))
+	for {
+		peak := atomic.LoadInt32(&s.sendPeak)
+		if n <= peak || atomic.CompareAndSwapInt32(&s.sendPeak, peak, n) {
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package net
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// 没有启动写循环的session 队列不会被消费
+func idleSession(t *testing.T, policy OverflowPolicy) *Session {
+	t.Helper()
+	sm := NewManagerWithConfig(&Config{SendQueue: &SendQueueConfig{Size: 4, Policy: policy, BlockTimeout: 20 * time.Millisecond}})
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	return sm.NewSession(a)
+}
+
+func TestSendQueueOverflow(t *testing.T) {
+	cases := []struct {
+		policy OverflowPolicy
+		err    error
+	}{
+		{OverflowBlock, ErrSendTimeout},
+		{OverflowDropNewest, ErrSendQueueFull},
+		{OverflowDropOldest, nil},
+		{OverflowDisconnect, ErrSendQueueFull},
+	}
+	for _, c := range cases {
+		s := idleSession(t, c.policy)
+		for i := 0; i < 4; i++ {
+			if err := s.Send(i); err != nil {
+				t.Fatal(c.policy, err)
+			}
+		}
+		if err := s.Send(4); !errors.Is(err, c.err) {
+			t.Fatal(c.policy, err)
+		}
+		st := s.SendStats()
+		if st.QueueCap != 4 || st.Peak != 4 || st.Dropped != 1 {
+			t.Fatalf("%d %+v", c.policy, st)
+		}
+		switch c.policy {
+		case OverflowDropOldest:
+			if first := (<-s.sendChan).(int); first != 1 {
+				t.Fatal("oldest not dropped", first)
+			}
+		case OverflowDisconnect:
+			if !s.IsClosed() {
+				t.Fatal("not disconnected")
+			}
+			if err := s.Send(5); !errors.Is(err, ErrSessionClosed) {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSendQueueBlock(t *testing.T) {
+	s := idleSession(t, OverflowBlock)
+	for i := 0; i < 4; i++ {
+		_ = s.Send(i)
+	}
+	// 有空位后阻塞的Send继续
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-s.sendChan
+	}()
+	if err := s.Send(4); err != nil {
+		t.Fatal(err)
+	}
+}
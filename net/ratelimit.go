@@ -0,0 +1,250 @@
+package net
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// LimitAction 超过session限流后的处理方式
+type LimitAction int32
+
+const (
+	LimitWarn     LimitAction = iota // 只打印日志和回调OnViolation 消息照常处理
+	LimitThrottle                    // 暂停读取直到有令牌 利用tcp的流控让客户端慢下来
+	LimitKick                        // 断开连接 不会断线恢复
+)
+
+type ViolationType int32
+
+const (
+	ViolationMsgRate   ViolationType = iota // session每秒消息数超过限制
+	ViolationByteRate                       // session每秒字节数超过限制
+	ViolationConnPerIP                      // 同一个ip的连接数超过限制 连接被拒绝
+	ViolationConnRate                       // 同一个ip每秒新建连接数超过限制 连接被拒绝
+)
+
+// Violation 违反限流的信息 连接被拒绝时Session为nil
+type Violation struct {
+	Type    ViolationType
+	IP      string
+	Session *Session
+	Action  LimitAction
+}
+
+// RateLimitConfig 限流设置 各项为0表示不限制
+type RateLimitConfig struct {
+	MsgPerSec       float64            // 每个session每秒最多收到的包数
+	MsgBurst        int                // 包数允许的突发 默认等于MsgPerSec
+	BytesPerSec     float64            // 每个session每秒最多收到的字节数
+	BytesBurst      int                // 字节数允许的突发 默认等于BytesPerSec
+	Action          LimitAction        // 超过session限流后的处理方式
+	MaxConnPerIP    int                // 同一个ip最多的连接数
+	ConnPerSecPerIP float64            // 同一个ip每秒最多新建的连接数
+	ConnBurstPerIP  int                // 新建连接允许的突发 默认等于ConnPerSecPerIP
+	OnViolation     func(v *Violation) // 违反限流时回调 在session的读协程或者监听协程中调用 需要注意并发安全
+}
+
+// 令牌桶
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(rate, 1)
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// 取n个令牌 不够的话返回需要等待的时间 令牌照样扣除 可能变成负数
+func (b *tokenBucket) take(n float64) (bool, time.Duration) {
+	b.refill(time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return true, 0
+	}
+	return false, time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// 取1个令牌 不够的话不扣除
+func (b *tokenBucket) allow() bool {
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) full() bool {
+	b.refill(time.Now())
+	return b.tokens >= b.burst
+}
+
+// 每个ip的连接状态
+type ipState struct {
+	conns  int
+	bucket *tokenBucket
+}
+
+type ipLimiter struct {
+	mu      sync.Mutex
+	ips     map[string]*ipState
+	accepts int
+}
+
+func (sm *Manager) initRateLimit(config *RateLimitConfig) {
+	if config == nil {
+		return
+	}
+	rc := *config
+	sm.rateLimit = &rc
+	sm.ipLimiter = &ipLimiter{ips: map[string]*ipState{}}
+}
+
+func (sm *Manager) violate(v *Violation) {
+	if v.Session != nil {
+		log.Sugar.Warnf("session rate limit violation: %d, sesid: %d, ip: %s, action: %d", v.Type, v.Session.ID(), v.IP, v.Action)
+	} else {
+		log.Sugar.Warnf("connection rate limit violation: %d, ip: %s", v.Type, v.IP)
+	}
+	if sm.rateLimit.OnViolation != nil {
+		sm.rateLimit.OnViolation(v)
+	}
+}
+
+func connIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	return hostOf(addr.String())
+}
+
+// 新连接按照ip检查连接数和建立连接的速度
+func (sm *Manager) acceptIP(ip string) bool {
+	rc := sm.rateLimit
+	if rc == nil || (rc.MaxConnPerIP <= 0 && rc.ConnPerSecPerIP <= 0) {
+		return true
+	}
+	l := sm.ipLimiter
+	l.mu.Lock()
+	l.accepts++
+	// 定期清理没有连接并且令牌已经恢复的ip
+	if l.accepts%1024 == 0 {
+		for k, st := range l.ips {
+			if st.conns == 0 && (st.bucket == nil || st.bucket.full()) {
+				delete(l.ips, k)
+			}
+		}
+	}
+	st, ok := l.ips[ip]
+	if !ok {
+		st = &ipState{}
+		if rc.ConnPerSecPerIP > 0 {
+			st.bucket = newTokenBucket(rc.ConnPerSecPerIP, rc.ConnBurstPerIP)
+		}
+		l.ips[ip] = st
+	}
+	var violation ViolationType = -1
+	if rc.MaxConnPerIP > 0 && st.conns >= rc.MaxConnPerIP {
+		violation = ViolationConnPerIP
+	} else if st.bucket != nil && !st.bucket.allow() {
+		violation = ViolationConnRate
+	} else {
+		st.conns++
+	}
+	l.mu.Unlock()
+
+	if violation >= 0 {
+		sm.violate(&Violation{Type: violation, IP: ip, Action: LimitKick})
+		return false
+	}
+	return true
+}
+
+func (sm *Manager) releaseIP(ip string) {
+	rc := sm.rateLimit
+	if rc == nil || (rc.MaxConnPerIP <= 0 && rc.ConnPerSecPerIP <= 0) {
+		return
+	}
+	l := sm.ipLimiter
+	l.mu.Lock()
+	if st, ok := l.ips[ip]; ok && st.conns > 0 {
+		st.conns--
+	}
+	l.mu.Unlock()
+}
+
+// session的限流 只在读协程中使用
+type sessionLimit struct {
+	msgs  *tokenBucket
+	bytes *tokenBucket
+}
+
+func (sm *Manager) newSessionLimit() *sessionLimit {
+	rc := sm.rateLimit
+	if rc == nil || (rc.MsgPerSec <= 0 && rc.BytesPerSec <= 0) {
+		return nil
+	}
+	sl := &sessionLimit{}
+	if rc.MsgPerSec > 0 {
+		sl.msgs = newTokenBucket(rc.MsgPerSec, rc.MsgBurst)
+	}
+	if rc.BytesPerSec > 0 {
+		sl.bytes = newTokenBucket(rc.BytesPerSec, rc.BytesBurst)
+	}
+	return sl
+}
+
+// 每读到一个包检查一次 返回false时需要断开连接
+func (s *Session) checkLimit(size int) bool {
+	sl := s.limit
+	if sl == nil {
+		return true
+	}
+	var wait time.Duration
+	var violation ViolationType = -1
+	if sl.msgs != nil {
+		if ok, w := sl.msgs.take(1); !ok {
+			violation, wait = ViolationMsgRate, w
+		}
+	}
+	if sl.bytes != nil {
+		if ok, w := sl.bytes.take(float64(size)); !ok {
+			if violation < 0 {
+				violation = ViolationByteRate
+			}
+			wait = max(wait, w)
+		}
+	}
+	if violation < 0 {
+		return true
+	}
+
+	action := s.manager.rateLimit.Action
+	s.manager.violate(&Violation{Type: violation, IP: s.ip, Session: s, Action: action})
+	switch action {
+	case LimitThrottle:
+		select {
+		case <-time.After(wait):
+		case <-s.closeChan:
+		}
+	case LimitKick:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package net
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	if !b.allow() || !b.allow() || b.allow() {
+		t.Fatal("burst")
+	}
+	// take会扣成负数 返回需要等待的时间
+	ok, wait := b.take(1)
+	if ok || wait < 50*time.Millisecond || wait > 200*time.Millisecond {
+		t.Fatal(ok, wait)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("refill")
+	}
+}
+
+// 记录违规类型的回调
+func violations(types *[4]atomic.Int32) func(v *Violation) {
+	return func(v *Violation) { types[v.Type].Add(1) }
+}
+
+func TestRateLimitConnPerIP(t *testing.T) {
+	var types [4]atomic.Int32
+	sh := &echoHandler{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, RateLimit: &RateLimitConfig{MaxConnPerIP: 2, OnViolation: violations(&types)}}, nil)
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitFor(t, "violation", func() bool { return types[ViolationConnPerIP].Load() == 1 })
+	if sh.open.Load() != 2 {
+		t.Fatal("open", sh.open.Load())
+	}
+	// 断开一个之后可以再连
+	_ = conns[0].Close()
+	waitFor(t, "close", func() bool { return sh.close.Load() == 1 })
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "reopen", func() bool { return sh.open.Load() == 3 })
+}
+
+func TestRateLimitConnRate(t *testing.T) {
+	var types [4]atomic.Int32
+	sh := &echoHandler{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, RateLimit: &RateLimitConfig{ConnPerSecPerIP: 0.1, ConnBurstPerIP: 1, OnViolation: violations(&types)}}, nil)
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	waitFor(t, "violation", func() bool { return types[ViolationConnRate].Load() == 1 })
+	if sh.open.Load() != 1 {
+		t.Fatal("open", sh.open.Load())
+	}
+}
+
+func TestRateLimitSession(t *testing.T) {
+	start := func(action LimitAction, types *[4]atomic.Int32) (*echoHandler, *echoHandler, IConnector) {
+		sh := &echoHandler{}
+		_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, RateLimit: &RateLimitConfig{MsgPerSec: 20, MsgBurst: 5, Action: action, OnViolation: violations(types)}}, nil)
+		ch := &echoHandler{client: true}
+		_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch}, nil)
+		for i := 0; i < 10; i++ {
+			_ = c.Session().Send(i)
+		}
+		return sh, ch, c
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		var types [4]atomic.Int32
+		sh, _, _ := start(LimitWarn, &types)
+		waitFor(t, "all msgs", func() bool { return sh.got.Load() == 10 })
+		if types[ViolationMsgRate].Load() == 0 {
+			t.Fatal("no violation")
+		}
+	})
+
+	t.Run("throttle", func(t *testing.T) {
+		var types [4]atomic.Int32
+		begin := time.Now()
+		sh, _, _ := start(LimitThrottle, &types)
+		waitFor(t, "all msgs", func() bool { return sh.got.Load() == 10 })
+		// 突发5条 剩下5条按每秒20条读取
+		if elapsed := time.Since(begin); elapsed < 200*time.Millisecond {
+			t.Fatal("not throttled", elapsed)
+		}
+		if sh.close.Load() != 0 {
+			t.Fatal("throttle closed session")
+		}
+	})
+
+	t.Run("kick", func(t *testing.T) {
+		var types [4]atomic.Int32
+		sh, _, _ := start(LimitKick, &types)
+		waitFor(t, "kicked", func() bool { return sh.close.Load() >= 1 })
+		if sh.got.Load() >= 10 {
+			t.Fatal("kick delivered all msgs")
+		}
+	})
+}
+
+func TestRateLimitBytes(t *testing.T) {
+	var types [4]atomic.Int32
+	sh := &echoHandler{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, RateLimit: &RateLimitConfig{BytesPerSec: 100, Action: LimitKick, OnViolation: violations(&types)}}, nil)
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: &echoHandler{client: true}}, nil)
+	_ = c.Session().Send(string(make([]byte, 500)))
+	waitFor(t, "violation", func() bool { return types[ViolationByteRate].Load() >= 1 })
+	waitFor(t, "kicked", func() bool { return sh.close.Load() >= 1 })
+}
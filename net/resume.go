@@ -0,0 +1,294 @@
+package net
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/murang/potato/log"
+)
+
+// 断线恢复流程 在加密握手之后进行 恢复帧不计入消息序号
+// 1. 客户端 -> 服务端 frameResume【token(新连接为空) + 客户端已收到的消息数(8字节)】
+// 2. 服务端 -> 客户端 frameResume【token(16字节) + 服务端已收到的消息数(8字节)】
+//    token和客户端发送的一样表示恢复成功 否则为新的session 客户端需要丢弃旧session
+// 3. 双方把对方没有收到的消息按顺序重发一遍
+// 消息序号不在包中传输 双方各自按照顺序计数 收到的消息每攒够一定数量就回一个frameAck【已收到的消息数(8字节)】
+// 发送方收到ack后才会把消息从未确认队列中删除
+
+const (
+	frameResume byte = 0x03
+	frameAck    byte = 0x04
+)
+
+const (
+	resumeTokenSize = 16
+	resumeAckEvery  = 16 // 每收到多少条消息回一次ack
+)
+
+var (
+	ErrResumeHandshake = errors.New("session resume handshake failed")
+	ErrResumeSeq       = errors.New("session resume seq not match")
+)
+
+// ResumeConfig 断线恢复设置 服务端和客户端需要同时设置
+type ResumeConfig struct {
+	GraceWindow time.Duration // 断线后保留session的时间 超时后才会触发OnSessionClose 默认30秒
+	BufferSize  int           // 未确认消息的最大缓存数量 超过后断线将不能恢复 默认1024
+}
+
+type sessionResume struct {
+	token         []byte
+	mu            sync.Mutex
+	sendSeq       uint64   // 已经发送(包括缓存)的消息数
+	ackedSeq      uint64   // 对方确认收到的消息数
+	recvSeq       uint64   // 已经收到的消息数
+	unacked       [][]byte // 未确认的消息 序号为ackedSeq+1开始
+	broken        bool     // 缓存溢出过 不能再恢复
+	bufferSize    int
+	attachChan    chan *sessionLink // 恢复时把新连接交给写循环
+	suspendNotify chan struct{}     // 断线时通知连接器重连
+}
+
+func newSessionResume(token []byte, bufferSize int) *sessionResume {
+	return &sessionResume{
+		token:         token,
+		bufferSize:    bufferSize,
+		attachChan:    make(chan *sessionLink),
+		suspendNotify: make(chan struct{}, 1),
+	}
+}
+
+func newResumeToken() []byte {
+	token := make([]byte, resumeTokenSize)
+	_, _ = rand.Read(token)
+	return token
+}
+
+func (r *sessionResume) notifySuspend() {
+	select {
+	case r.suspendNotify <- struct{}{}:
+	default:
+	}
+}
+
+// 发送的消息放入未确认队列 队列满了返回false 之后这个session断线就不能恢复了
+func (r *sessionResume) push(data []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sendSeq++
+	if r.broken {
+		return false
+	}
+	if len(r.unacked) >= r.bufferSize {
+		r.broken = true
+		r.unacked = nil
+		return false
+	}
+	r.unacked = append(r.unacked, data)
+	return true
+}
+
+// 对方确认收到了seq条消息
+func (r *sessionResume) ack(seq uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if seq < r.ackedSeq || seq > r.sendSeq {
+		return ErrResumeSeq
+	}
+	if !r.broken {
+		n := seq - r.ackedSeq
+		clear(r.unacked[:n])
+		r.unacked = r.unacked[n:]
+	}
+	r.ackedSeq = seq
+	return nil
+}
+
+// 收到一条消息 返回是否需要回ack
+func (r *sessionResume) recv() (uint64, bool) {
+	seq := atomic.AddUint64(&r.recvSeq, 1)
+	return seq, seq%resumeAckEvery == 0
+}
+
+func (s *Session) canResume() bool {
+	if s.resume == nil || s.IsClosed() {
+		return false
+	}
+	s.resume.mu.Lock()
+	defer s.resume.mu.Unlock()
+	return !s.resume.broken
+}
+
+// 把对方没有收到的消息重新发送
+func (s *Session) replay(link *sessionLink) error {
+	if err := s.resume.ack(link.peerRecv); err != nil {
+		return err
+	}
+	s.resume.mu.Lock()
+	if s.resume.broken {
+		s.resume.mu.Unlock()
+		return ErrResumeSeq
+	}
+	pending := append([][]byte(nil), s.resume.unacked...)
+	s.resume.mu.Unlock()
+	for _, data := range pending {
+		if err := s.sendData(link, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) onRecvData() {
+	if s.resume == nil {
+		return
+	}
+	if seq, needAck := s.resume.recv(); needAck {
+		payload := make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, seq)
+		s.sendCtrl(packFrame(frameAck, payload))
+	}
+}
+
+func (s *Session) onAck(payload []byte) error {
+	if s.resume == nil || len(payload) < 8 {
+		return ErrMinPacket
+	}
+	return s.resume.ack(binary.BigEndian.Uint64(payload))
+}
+
+func packResume(token []byte, recvSeq uint64) []byte {
+	payload := make([]byte, len(token)+8)
+	copy(payload, token)
+	binary.BigEndian.PutUint64(payload[len(token):], recvSeq)
+	return packFrame(frameResume, payload)
+}
+
+func unpackResume(pkt []byte) (token []byte, recvSeq uint64, err error) {
+	if len(pkt) < 9 || pkt[0]&frameTypeMask != frameResume {
+		return nil, 0, ErrResumeHandshake
+	}
+	payload := pkt[1:]
+	token = payload[:len(payload)-8]
+	if len(token) != 0 && len(token) != resumeTokenSize {
+		return nil, 0, ErrResumeHandshake
+	}
+	return token, binary.BigEndian.Uint64(payload[len(token):]), nil
+}
+
+// 断开旧连接 等旧的读循环结束 保证收到的消息数不会再变化
+func (s *Session) detachLink() {
+	link := s.currentLink()
+	_ = link.conn.Close()
+	select {
+	case <-link.readDone:
+	case <-time.After(time.Second):
+	}
+}
+
+// 把新连接交给旧session的写循环
+func (s *Session) attach(link *sessionLink) bool {
+	select {
+	case s.resume.attachChan <- link:
+		return true
+	case <-s.closeChan:
+		return false
+	}
+}
+
+// 服务端恢复握手 sess为新连接生成的临时session
+// 找到可以恢复的旧session就把新连接交给它 返回旧session和true 否则sess作为新session返回
+func (sm *Manager) serverResume(sess *Session) (*Session, bool, error) {
+	link := sess.currentLink()
+	if err := link.conn.SetDeadline(time.Now().Add(time.Duration(sm.timeout) * time.Second)); err != nil {
+		return nil, false, err
+	}
+	pkt, err := sess.readMessageBytes(link)
+	if err != nil {
+		return nil, false, err
+	}
+	token, peerRecv, err := unpackResume(pkt)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(token) > 0 {
+		if v, ok := sm.resumeMap.Load(string(token)); ok {
+			old := v.(*Session)
+			if old.canResume() {
+				old.detachLink()
+				recvSeq := atomic.LoadUint64(&old.resume.recvSeq)
+				if err = sess.sendMessageBytes(link, packResume(token, recvSeq)); err != nil {
+					return nil, false, err
+				}
+				if err = link.conn.SetDeadline(time.Time{}); err != nil {
+					return nil, false, err
+				}
+				link.peerRecv = peerRecv
+				if old.attach(link) {
+					return old, true, nil
+				}
+				return nil, false, ErrResumeHandshake
+			}
+		}
+	}
+
+	// 新session
+	sess.resume = newSessionResume(newResumeToken(), sm.resume.BufferSize)
+	if err = sess.sendMessageBytes(link, packResume(sess.resume.token, 0)); err != nil {
+		return nil, false, err
+	}
+	sm.resumeMap.Store(string(sess.resume.token), sess)
+	return sess, false, link.conn.SetDeadline(time.Time{})
+}
+
+// 客户端恢复握手 prev为断线前的session
+// 服务端同意恢复的话把新连接交给prev 返回prev和true 否则sess作为新session返回 prev会被关闭
+func (sm *Manager) clientResume(sess, prev *Session) (*Session, bool, error) {
+	link := sess.currentLink()
+	if err := link.conn.SetDeadline(time.Now().Add(time.Duration(sm.timeout) * time.Second)); err != nil {
+		return nil, false, err
+	}
+	var token []byte
+	var recvSeq uint64
+	if prev != nil && prev.canResume() {
+		prev.detachLink()
+		token = prev.resume.token
+		recvSeq = atomic.LoadUint64(&prev.resume.recvSeq)
+	}
+	if err := sess.sendMessageBytes(link, packResume(token, recvSeq)); err != nil {
+		return nil, false, err
+	}
+	pkt, err := sess.readMessageBytes(link)
+	if err != nil {
+		return nil, false, err
+	}
+	serverToken, peerRecv, err := unpackResume(pkt)
+	if err != nil || len(serverToken) == 0 {
+		return nil, false, ErrResumeHandshake
+	}
+	if err = link.conn.SetDeadline(time.Time{}); err != nil {
+		return nil, false, err
+	}
+
+	if len(token) > 0 && bytes.Equal(token, serverToken) {
+		link.peerRecv = peerRecv
+		if prev.attach(link) {
+			return prev, true, nil
+		}
+		return nil, false, ErrResumeHandshake
+	}
+
+	// 服务端已经没有旧session了 旧session也没有意义了
+	if prev != nil {
+		log.Sugar.Infof("session resume rejected: %d", prev.ID())
+		prev.Close()
+	}
+	sess.resume = newSessionResume(serverToken, sm.resume.BufferSize)
+	return sess, false, nil
+}
@@ -0,0 +1,160 @@
+package net
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestResume(t *testing.T) {
+	cfg := func(h IMsgHandler) *Config {
+		return &Config{MsgHandler: h, Crypto: &CryptoConfig{}, Resume: &ResumeConfig{GraceWindow: 2 * time.Second}}
+	}
+	sh := &echoHandler{}
+	sm, addr := startServer(t, "tcp", cfg(sh), nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, cfg(ch), nil)
+	sess := c.Session()
+	for i := 0; i < 40; i++ {
+		_ = sess.Send(i)
+		if i == 20 {
+			_ = sess.Conn().Close()
+		}
+	}
+	waitFor(t, "all echoed", func() bool { return ch.got.Load() == 40 })
+	if sh.got.Load() != 40 || c.Session() != sess {
+		t.Fatal("server got", sh.got.Load())
+	}
+	// 恢复成功的话双方都不会触发open和close
+	if sh.open.Load() != 1 || sh.close.Load() != 0 || ch.open.Load() != 1 || ch.close.Load() != 0 {
+		t.Fatal("resume fired session events")
+	}
+
+	// 服务端连接断开后客户端连不上 超过等待时间双方都关闭
+	for _, ln := range sm.listeners {
+		ln.Stop()
+	}
+	_ = firstSession(sm).Conn().Close()
+	waitFor(t, "grace timeout", func() bool { return sh.close.Load() == 1 && ch.close.Load() == 1 })
+}
+
+func TestResumeOversize(t *testing.T) {
+	framer := NewU32Framer(binary.BigEndian, 1024)
+	cfg := func(h IMsgHandler) *Config {
+		return &Config{MsgHandler: h, Framer: framer, Resume: &ResumeConfig{GraceWindow: 2 * time.Second}}
+	}
+	sm, addr := startServer(t, "tcp", cfg(&echoHandler{}), nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, cfg(ch), nil)
+	waitFor(t, "server session", func() bool { return firstSession(sm) != nil })
+	ss := firstSession(sm)
+	link := ss.currentLink()
+
+	// 超长的消息只丢弃 不会当作断线 也不会在恢复时重发
+	_ = ss.Send(strings.Repeat("x", 2000))
+	_ = ss.Send("small")
+	waitFor(t, "small msg", func() bool { return ch.got.Load() == 1 })
+	time.Sleep(100 * time.Millisecond)
+	if ss.currentLink() != link || ss.IsClosed() {
+		t.Fatal("oversize msg broke the link")
+	}
+	if ch.last.Load() != "small" {
+		t.Fatal("got", ch.last.Load())
+	}
+	ss.resume.mu.Lock()
+	unacked := len(ss.resume.unacked)
+	ss.resume.mu.Unlock()
+	if unacked != 1 {
+		t.Fatal("unacked", unacked)
+	}
+
+	// 之后断线恢复也不会重发超长的消息
+	_ = c.Session().Conn().Close()
+	waitFor(t, "resumed", func() bool { return ss.currentLink() != link })
+	_ = ss.Send("after")
+	waitFor(t, "after resume", func() bool { return ch.last.Load() == "after" })
+}
+
+func TestOversizeDirectWrite(t *testing.T) {
+	regTestMsgs()
+	framer := NewU32Framer(binary.BigEndian, 1024)
+	cfg := func(h IMsgHandler) *Config { return &Config{MsgHandler: h, Framer: framer, Codec: &PbCodec{}} }
+	sm, addr := startServer(t, "tcp", cfg(&echoHandler{}), nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, cfg(ch), nil)
+	waitFor(t, "server session", func() bool { return firstSession(sm) != nil })
+	ss := firstSession(sm)
+	_ = ss.Send(wrapperspb.String(strings.Repeat("x", 2000)))
+	_ = ss.Send(wrapperspb.String("small"))
+	waitFor(t, "small msg", func() bool { return ch.got.Load() == 1 })
+	if ss.IsClosed() || c.Session() == nil {
+		t.Fatal("oversize msg closed the session")
+	}
+}
+
+func TestOversizeFlush(t *testing.T) {
+	sm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{}, Framer: NewU32Framer(binary.BigEndian, 1024)})
+	a, b := net.Pipe()
+	defer b.Close()
+	s := sm.NewSession(a)
+	defer s.Close()
+	// 关闭前写队列时超长的消息也只丢弃 后面的消息照常发出
+	_ = s.Send(strings.Repeat("x", 2000))
+	_ = s.Send("bye")
+	go s.flush(s.currentLink())
+	_ = b.SetReadDeadline(time.Now().Add(2 * time.Second))
+	pkt, err := NewU32Framer(binary.BigEndian, 1024).ReadFrame(b)
+	if err != nil || string(pkt) != `"bye"` {
+		t.Fatal(string(pkt), err)
+	}
+}
+
+// 自定义的IFramer 记录写出的次数
+type countFramer struct {
+	writes atomic.Int32
+}
+
+func (f *countFramer) ReadFrame(r io.Reader) ([]byte, error) { return defaultFramer.ReadFrame(r) }
+func (f *countFramer) WriteFrame(w io.Writer, data []byte) error {
+	f.writes.Add(1)
+	return defaultFramer.WriteFrame(w, data)
+}
+
+type limitFramer struct {
+	countFramer
+}
+
+func (f *limitFramer) MaxPacketSize() int { return 1024 }
+
+func TestCustomFramerSize(t *testing.T) {
+	// 没有实现IMaxPacketSize的不检查 也不会为了检查多写一次
+	cf := &countFramer{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, nil)
+	ch := &echoHandler{client: true}
+	_, c := startClient(t, "tcp", addr, &Config{MsgHandler: ch, Framer: cf}, nil)
+	for i := 0; i < 10; i++ {
+		_ = c.Session().Send("hi")
+	}
+	waitFor(t, "echo", func() bool { return ch.got.Load() == 10 })
+	if cf.writes.Load() != 10 {
+		t.Fatal("writes", cf.writes.Load())
+	}
+
+	// 实现了的按照它的限制丢弃超长的消息
+	lf := &limitFramer{}
+	_, addr = startServer(t, "tcp", &Config{MsgHandler: &echoHandler{}}, nil)
+	ch = &echoHandler{client: true}
+	_, c = startClient(t, "tcp", addr, &Config{MsgHandler: ch, Framer: lf}, nil)
+	_ = c.Session().Send(strings.Repeat("x", 2000))
+	_ = c.Session().Send("small")
+	waitFor(t, "small", func() bool { return ch.got.Load() == 1 })
+	if ch.last.Load() != "small" || lf.writes.Load() != 1 || c.Session().IsClosed() {
+		t.Fatal("oversize msg", lf.writes.Load())
+	}
+}
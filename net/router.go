@@ -0,0 +1,137 @@
+package net
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/murang/potato/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// MsgFunc 处理一条消息
+type MsgFunc func(session *Session, msg any)
+
+// Middleware 中间件 包装下一个MsgFunc 可以在前后加逻辑 不调用next就是拦截消息
+type Middleware func(next MsgFunc) MsgFunc
+
+// Router 按消息类型路由的IMsgHandler 替代手写的map[reflect.Type]func
+//
+//	r := net.NewRouter()
+//	r.Use(net.Recovery(), net.Logging())
+//	net.Handle(r, func(s *net.Session, msg *nice.C2S_Hello) {...})
+type Router struct {
+	handlers  map[reflect.Type]MsgFunc
+	chain     MsgFunc
+	mws       []Middleware
+	inRoutine bool
+	onOpen    func(session *Session)
+	onClose   func(session *Session)
+	onUnknown func(session *Session, msg any)
+}
+
+func NewRouter() *Router {
+	r := &Router{
+		handlers: map[reflect.Type]MsgFunc{},
+	}
+	r.chain = r.dispatch
+	return r
+}
+
+// Handle 注册消息处理函数 同一个类型重复注册会panic
+func Handle[T proto.Message](r *Router, f func(session *Session, msg T)) {
+	r.register(reflect.TypeFor[T](), func(session *Session, msg any) {
+		f(session, msg.(T))
+	})
+}
+
+// HandleRequest 注册请求处理函数 返回的消息通过Session.Reply自动发回给session
+// 返回*CodeError时通过ReplyError回复错误码 其他错误不回复
+func HandleRequest[Req, Resp proto.Message](r *Router, f func(session *Session, req Req) (Resp, error)) {
+	r.register(reflect.TypeFor[Req](), func(session *Session, msg any) {
+		resp, err := f(session, msg.(Req))
+		if err != nil {
+			var codeErr *CodeError
+			if errors.As(err, &codeErr) {
+				if err = session.ReplyError(msg, codeErr.Code); err != nil {
+					log.Sugar.Warnf("router reply %T err, sesid: %d, err: %s", msg, session.ID(), err)
+				}
+				return
+			}
+			log.Sugar.Warnf("router handle %T err, sesid: %d, err: %s", msg, session.ID(), err)
+			return
+		}
+		if v := reflect.ValueOf(resp); v.IsValid() && !v.IsNil() {
+			if err = session.Reply(msg, resp); err != nil {
+				log.Sugar.Warnf("router send %T err, sesid: %d, err: %s", resp, session.ID(), err)
+			}
+		}
+	})
+}
+
+func (r *Router) register(t reflect.Type, f MsgFunc) {
+	if _, ok := r.handlers[t]; ok {
+		panic("router handler repeated: " + t.String())
+	}
+	r.handlers[t] = f
+}
+
+// Use 添加中间件 先添加的在外层 需要在Start之前设置
+func (r *Router) Use(mws ...Middleware) {
+	r.mws = append(r.mws, mws...)
+	r.chain = r.dispatch
+	for i := len(r.mws) - 1; i >= 0; i-- {
+		r.chain = r.mws[i](r.chain)
+	}
+}
+
+// OnOpen session打开时回调
+func (r *Router) OnOpen(f func(session *Session)) {
+	r.onOpen = f
+}
+
+// OnClose session关闭时回调
+func (r *Router) OnClose(f func(session *Session)) {
+	r.onClose = f
+}
+
+// OnUnknown 收到没有注册的消息时回调 默认打印错误日志
+func (r *Router) OnUnknown(f func(session *Session, msg any)) {
+	r.onUnknown = f
+}
+
+// SetMsgInRoutine 消息是否在session的协程中处理 参考IMsgHandler.IsMsgInRoutine
+func (r *Router) SetMsgInRoutine(inRoutine bool) {
+	r.inRoutine = inRoutine
+}
+
+func (r *Router) dispatch(session *Session, msg any) {
+	if f, ok := r.handlers[reflect.TypeOf(msg)]; ok {
+		f(session, msg)
+		return
+	}
+	if r.onUnknown != nil {
+		r.onUnknown(session, msg)
+		return
+	}
+	log.Sugar.Errorf("router got unknown msg: %T, sesid: %d", msg, session.ID())
+}
+
+func (r *Router) IsMsgInRoutine() bool {
+	return r.inRoutine
+}
+
+func (r *Router) OnSessionOpen(session *Session) {
+	if r.onOpen != nil {
+		r.onOpen(session)
+	}
+}
+
+func (r *Router) OnSessionClose(session *Session) {
+	if r.onClose != nil {
+		r.onClose(session)
+	}
+}
+
+func (r *Router) OnMsg(session *Session, msg any) {
+	r.chain(session, msg)
+}
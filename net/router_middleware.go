@@ -0,0 +1,116 @@
+package net
+
+import (
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/murang/potato/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// Recovery 处理消息时panic的话打印堆栈 不影响其他消息
+func Recovery() Middleware {
+	return func(next MsgFunc) MsgFunc {
+		return func(session *Session, msg any) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Sugar.Errorf("router handle %T panic, sesid: %d, err: %v\n%s", msg, session.ID(), err, debug.Stack())
+				}
+			}()
+			next(session, msg)
+		}
+	}
+}
+
+// Logging 打印每条消息和处理耗时 debug级别
+func Logging() Middleware {
+	return func(next MsgFunc) MsgFunc {
+		return func(session *Session, msg any) {
+			start := time.Now()
+			next(session, msg)
+			log.Sugar.Debugf("router handle %T, sesid: %d, cost: %s", msg, session.ID(), time.Since(start))
+		}
+	}
+}
+
+// Metrics 每条消息处理完后上报消息名和耗时 可以对接prometheus等
+func Metrics(report func(name string, cost time.Duration)) Middleware {
+	return func(next MsgFunc) MsgFunc {
+		return func(session *Session, msg any) {
+			start := time.Now()
+			next(session, msg)
+			report(msgName(msg), time.Since(start))
+		}
+	}
+}
+
+// Auth 没有通过check的session只能发送allow中的消息(比如登录) 其他消息丢弃
+func Auth(check func(session *Session) bool, allow ...proto.Message) Middleware {
+	allowed := make(map[reflect.Type]struct{}, len(allow))
+	for _, msg := range allow {
+		allowed[reflect.TypeOf(msg)] = struct{}{}
+	}
+	return func(next MsgFunc) MsgFunc {
+		return func(session *Session, msg any) {
+			if _, ok := allowed[reflect.TypeOf(msg)]; ok || check(session) {
+				next(session, msg)
+				return
+			}
+			log.Sugar.Warnf("router drop unauthorized msg: %T, sesid: %d", msg, session.ID())
+		}
+	}
+}
+
+// RateLimit 每个session每秒最多处理rate条消息 允许burst条的突发 超过的消息丢弃
+func RateLimit(rate float64, burst int) Middleware {
+	limiter := &sessionLimiter{rate: rate, burst: burst}
+	return func(next MsgFunc) MsgFunc {
+		return func(session *Session, msg any) {
+			if limiter.allow(session) {
+				next(session, msg)
+				return
+			}
+			log.Sugar.Warnf("router drop msg over rate limit: %T, sesid: %d", msg, session.ID())
+		}
+	}
+}
+
+func msgName(msg any) string {
+	if m, ok := msg.(proto.Message); ok {
+		return string(proto.MessageName(m))
+	}
+	return reflect.TypeOf(msg).String()
+}
+
+type sessionLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[*Session]*tokenBucket
+	inserts int
+}
+
+func (l *sessionLimiter) allow(session *Session) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[session]
+	if !ok {
+		if l.buckets == nil {
+			l.buckets = map[*Session]*tokenBucket{}
+		}
+		l.inserts++
+		// 定期清理已经关闭的session
+		if l.inserts%1024 == 0 {
+			for s := range l.buckets {
+				if s.IsClosed() {
+					delete(l.buckets, s)
+				}
+			}
+		}
+		b = newTokenBucket(l.rate, l.burst)
+		l.buckets[session] = b
+	}
+	return b.allow()
+}
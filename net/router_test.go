@@ -0,0 +1,88 @@
+package net
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func routerSession(t *testing.T) *Session {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	return NewManagerWithConfig(&Config{}).NewSession(a)
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	var order []string
+	r.Use(func(next MsgFunc) MsgFunc {
+		return func(s *Session, msg any) {
+			order = append(order, "outer")
+			next(s, msg)
+		}
+	}, func(next MsgFunc) MsgFunc {
+		return func(s *Session, msg any) {
+			order = append(order, "inner")
+			next(s, msg)
+		}
+	})
+	var got string
+	Handle(r, func(s *Session, msg *wrapperspb.StringValue) { got = msg.Value })
+	var unknown any
+	r.OnUnknown(func(s *Session, msg any) { unknown = msg })
+
+	s := routerSession(t)
+	r.OnMsg(s, wrapperspb.String("potato"))
+	if got != "potato" || len(order) != 2 || order[0] != "outer" {
+		t.Fatal(got, order)
+	}
+	r.OnMsg(s, wrapperspb.Int32(1))
+	if _, ok := unknown.(*wrapperspb.Int32Value); !ok {
+		t.Fatal("unknown", unknown)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("repeated handler not rejected")
+		}
+	}()
+	Handle(r, func(s *Session, msg *wrapperspb.StringValue) {})
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	r := NewRouter()
+	var reported []string
+	authed := false
+	r.Use(Recovery(),
+		Metrics(func(name string, cost time.Duration) { reported = append(reported, name) }),
+		Auth(func(*Session) bool { return authed }, &wrapperspb.StringValue{}),
+		RateLimit(0.001, 3))
+	handled := 0
+	Handle(r, func(s *Session, msg *wrapperspb.StringValue) { handled++ })
+	Handle(r, func(s *Session, msg *wrapperspb.Int32Value) { handled++ })
+	Handle(r, func(s *Session, msg *wrapperspb.BoolValue) { panic("boom") })
+
+	s := routerSession(t)
+	// 没有登录时只放行登录消息
+	r.OnMsg(s, wrapperspb.Int32(1))
+	r.OnMsg(s, wrapperspb.String("login"))
+	if handled != 1 {
+		t.Fatal("auth", handled)
+	}
+	authed = true
+	// panic被Recovery接住 后面的消息照常处理 超过突发的消息被限流丢弃
+	r.OnMsg(s, wrapperspb.Bool(true))
+	r.OnMsg(s, wrapperspb.Int32(2))
+	r.OnMsg(s, wrapperspb.Int32(3))
+	if handled != 2 {
+		t.Fatal("rate limit", handled)
+	}
+	// panic的消息没有上报
+	if len(reported) != 4 || reported[0] != string(proto.MessageName(&wrapperspb.Int32Value{})) {
+		t.Fatal("metrics", reported)
+	}
+}
@@ -0,0 +1,223 @@
+package net
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// 加密握手流程 握手包不加密 直接使用IFramer收发
+// 1. 服务端 -> 客户端 【加密套件(1字节) + 服务端X25519公钥(32字节)】 设置了SignKey时后面再带上Ed25519签名(64字节)
+// 2. 客户端 -> 服务端 【客户端X25519公钥(32字节)】
+// 3. 双方通过ECDH得到共享密钥 再用HKDF分别派生出c2s和s2c两个方向的密钥
+// 之后每个包体都用AEAD加密 nonce为各方向独立递增的计数器 计数器不在包中传输
+// 收到重放或者乱序的包时计数器对不上 解密失败 连接会被关闭
+// 只有密钥交换的话不认证服务端身份 只能防被动窃听 中间人可以分别和双方握手
+// 服务端设置SignKey 客户端设置ServerKey固定服务端的签名公钥后 客户端会校验签名 中间人无法伪造
+
+type CipherSuite byte
+
+const (
+	CipherAESGCM           CipherSuite = iota + 1 // AES-256-GCM 有硬件加速的平台推荐使用
+	CipherChaCha20Poly1305                        // ChaCha20-Poly1305 移动端没有AES指令时性能更好
+)
+
+var (
+	ErrHandshake     = errors.New("secure handshake failed")
+	ErrDecryptPacket = errors.New("decrypt packet failed")
+	ErrNonceOverflow = errors.New("secure nonce overflow")
+	ErrServerKey     = errors.New("secure server key not match")
+)
+
+const (
+	securePubKeySize = 32
+	secureKeySize    = 32
+)
+
+var (
+	secureInfoC2S = []byte("potato c2s")
+	secureInfoS2C = []byte("potato s2c")
+	secureSignCtx = []byte("potato hello")
+)
+
+// CryptoConfig 传输加密设置 设置到net.Config后 所有session在打开前都会先进行密钥交换
+// 不设置SignKey和ServerKey时握手是匿名的 不认证服务端 只能防被动窃听 防不了中间人
+type CryptoConfig struct {
+	Cipher    CipherSuite        // 加密套件 由服务端决定 客户端以服务端下发的为准 默认AES-256-GCM
+	SignKey   ed25519.PrivateKey // 服务端的签名私钥 设置后握手时对服务端公钥签名
+	ServerKey ed25519.PublicKey  // 客户端固定的服务端签名公钥 设置后服务端必须带上正确的签名 否则握手失败
+}
+
+// SecureChannel 一个连接的加解密状态 收发各自只能在一个goroutine中使用
+type SecureChannel struct {
+	sealer    cipher.AEAD
+	opener    cipher.AEAD
+	sealNonce uint64
+	openNonce uint64
+	sealBuf   [12]byte
+	openBuf   [12]byte
+}
+
+// ServerHandshake 服务端发起密钥交换 传入signKey的话对握手包签名 客户端可以用对应的公钥校验
+func ServerHandshake(rw io.ReadWriter, framer IFramer, suite CipherSuite, signKey ...ed25519.PrivateKey) (*SecureChannel, error) {
+	if suite == 0 {
+		suite = CipherAESGCM
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serverPub := priv.PublicKey().Bytes()
+	hello := make([]byte, 1+securePubKeySize)
+	hello[0] = byte(suite)
+	copy(hello[1:], serverPub)
+	if len(signKey) > 0 && signKey[0] != nil {
+		hello = append(hello, ed25519.Sign(signKey[0], signedHello(hello))...)
+	}
+	if err = framer.WriteFrame(rw, hello); err != nil {
+		return nil, err
+	}
+
+	clientPub, err := framer.ReadFrame(rw)
+	if err != nil {
+		return nil, err
+	}
+	if len(clientPub) != securePubKeySize {
+		return nil, ErrHandshake
+	}
+	return newSecureChannel(priv, suite, serverPub, clientPub, false)
+}
+
+// ClientHandshake 客户端响应服务端的密钥交换 自己实现客户端的bot之类也可以直接使用
+// 传入serverKey的话校验服务端的签名 不传则不认证服务端
+func ClientHandshake(rw io.ReadWriter, framer IFramer, serverKey ...ed25519.PublicKey) (*SecureChannel, error) {
+	hello, err := framer.ReadFrame(rw)
+	if err != nil {
+		return nil, err
+	}
+	if len(hello) != 1+securePubKeySize && len(hello) != 1+securePubKeySize+ed25519.SignatureSize {
+		return nil, ErrHandshake
+	}
+	if len(serverKey) > 0 && serverKey[0] != nil {
+		if len(hello) != 1+securePubKeySize+ed25519.SignatureSize || len(serverKey[0]) != ed25519.PublicKeySize {
+			return nil, ErrServerKey
+		}
+		if !ed25519.Verify(serverKey[0], signedHello(hello[:1+securePubKeySize]), hello[1+securePubKeySize:]) {
+			return nil, ErrServerKey
+		}
+	}
+	suite := CipherSuite(hello[0])
+	serverPub := hello[1 : 1+securePubKeySize]
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	clientPub := priv.PublicKey().Bytes()
+	if err = framer.WriteFrame(rw, clientPub); err != nil {
+		return nil, err
+	}
+	return newSecureChannel(priv, suite, serverPub, clientPub, true)
+}
+
+// 签名的内容 加上固定前缀 避免签名被用到别的地方
+func signedHello(hello []byte) []byte {
+	msg := make([]byte, 0, len(secureSignCtx)+len(hello))
+	msg = append(msg, secureSignCtx...)
+	return append(msg, hello...)
+}
+
+func newSecureChannel(priv *ecdh.PrivateKey, suite CipherSuite, serverPub, clientPub []byte, isClient bool) (*SecureChannel, error) {
+	peer := clientPub
+	if isClient {
+		peer = serverPub
+	}
+	peerKey, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+	secret, err := priv.ECDH(peerKey)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+
+	// 双方公钥作为salt 保证每次握手派生出的密钥都不同
+	salt := make([]byte, 0, securePubKeySize*2)
+	salt = append(salt, serverPub...)
+	salt = append(salt, clientPub...)
+	c2s, err := hkdf.Key(sha256.New, secret, salt, string(secureInfoC2S), secureKeySize)
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := hkdf.Key(sha256.New, secret, salt, string(secureInfoS2C), secureKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &SecureChannel{}
+	sealKey, openKey := s2c, c2s
+	if isClient {
+		sealKey, openKey = c2s, s2c
+	}
+	if sc.sealer, err = newAEAD(suite, sealKey); err != nil {
+		return nil, err
+	}
+	if sc.opener, err = newAEAD(suite, openKey); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+func newAEAD(suite CipherSuite, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	}
+	return nil, ErrHandshake
+}
+
+// nonce为 4字节0 + 8字节大端计数器 收发各用一个buf 避免读写goroutine互相干扰
+func putNonce(buf *[12]byte, counter uint64) []byte {
+	binary.BigEndian.PutUint64(buf[4:], counter)
+	return buf[:]
+}
+
+// Seal 加密一个包体 返回新的切片
+func (sc *SecureChannel) Seal(data []byte) ([]byte, error) {
+	if sc.sealNonce == ^uint64(0) {
+		return nil, ErrNonceOverflow
+	}
+	out := make([]byte, 0, len(data)+sc.sealer.Overhead())
+	out = sc.sealer.Seal(out, putNonce(&sc.sealBuf, sc.sealNonce), data, nil)
+	sc.sealNonce++
+	return out, nil
+}
+
+// Open 解密一个包体 计数器对不上(重放 乱序 篡改)都会返回错误
+func (sc *SecureChannel) Open(data []byte) ([]byte, error) {
+	if sc.openNonce == ^uint64(0) {
+		return nil, ErrNonceOverflow
+	}
+	// 原地解密 复用读出来的包体内存
+	out, err := sc.opener.Open(data[:0], putNonce(&sc.openBuf, sc.openNonce), data, nil)
+	if err != nil {
+		return nil, ErrDecryptPacket
+	}
+	sc.openNonce++
+	return out, nil
+}
@@ -0,0 +1,121 @@
+package net
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+)
+
+// 在一对内存连接上握手 返回双方的结果
+func pipeHandshake(t *testing.T, suite CipherSuite, signKey ed25519.PrivateKey, serverKey ed25519.PublicKey) (*SecureChannel, *SecureChannel, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	type result struct {
+		sc  *SecureChannel
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sc, err := ServerHandshake(a, defaultFramer, suite, signKey)
+		if err != nil {
+			a.Close()
+		}
+		done <- result{sc, err}
+	}()
+	client, err := ClientHandshake(b, defaultFramer, serverKey)
+	if err != nil {
+		b.Close()
+	}
+	server := <-done
+	if err == nil {
+		err = server.err
+	}
+	return server.sc, client, err
+}
+
+func TestSecureChannel(t *testing.T) {
+	for _, suite := range []CipherSuite{CipherAESGCM, CipherChaCha20Poly1305} {
+		server, client, err := pipeHandshake(t, suite, nil, nil)
+		if err != nil {
+			t.Fatal(suite, err)
+		}
+		for _, msg := range []string{"hello", "", "potato"} {
+			sealed, _ := client.Seal([]byte(msg))
+			opened, err := server.Open(sealed)
+			if err != nil || string(opened) != msg {
+				t.Fatal(suite, msg, err)
+			}
+		}
+		// 重放的包计数器对不上
+		sealed, _ := server.Seal([]byte("once"))
+		replay := append([]byte(nil), sealed...)
+		if _, err = client.Open(sealed); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Open(replay); !errors.Is(err, ErrDecryptPacket) {
+			t.Fatal("replay accepted", err)
+		}
+		// 篡改的包
+		sealed, _ = client.Seal([]byte("tamper"))
+		sealed[0] ^= 1
+		if _, err = server.Open(sealed); !errors.Is(err, ErrDecryptPacket) {
+			t.Fatal("tamper accepted", err)
+		}
+	}
+}
+
+func TestSecureServerKey(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	server, client, err := pipeHandshake(t, 0, priv, pub)
+	if err != nil {
+		t.Fatal("pinned", err)
+	}
+	sealed, _ := server.Seal([]byte("hi"))
+	if opened, err := client.Open(sealed); err != nil || string(opened) != "hi" {
+		t.Fatal(err)
+	}
+	// 不校验的客户端也能和签名的服务端握手
+	if _, _, err = pipeHandshake(t, 0, priv, nil); err != nil {
+		t.Fatal("unpinned client", err)
+	}
+	// 签名的私钥不对 或者服务端没有签名 都当作中间人
+	if _, _, err = pipeHandshake(t, 0, priv, otherPub); !errors.Is(err, ErrServerKey) {
+		t.Fatal("wrong key", err)
+	}
+	if _, _, err = pipeHandshake(t, 0, nil, pub); !errors.Is(err, ErrServerKey) {
+		t.Fatal("unsigned server", err)
+	}
+}
+
+func TestSecureSession(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	for _, network := range []string{"tcp", "ws"} {
+		t.Run(network, func(t *testing.T) {
+			_, addr := startServer(t, network, &Config{MsgHandler: &echoHandler{}, Crypto: &CryptoConfig{Cipher: CipherChaCha20Poly1305, SignKey: priv}}, nil)
+			ch := &echoHandler{client: true}
+			_, c := startClient(t, network, addr, &Config{MsgHandler: ch, Crypto: &CryptoConfig{ServerKey: pub}}, nil)
+			for i := 0; i < 10; i++ {
+				_ = c.Session().Send(i)
+			}
+			waitFor(t, "echo", func() bool { return ch.got.Load() == 10 })
+		})
+	}
+
+	// 固定了别的公钥的客户端握手失败 不会生成session
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	sh := &echoHandler{}
+	_, addr := startServer(t, "tcp", &Config{MsgHandler: sh, Crypto: &CryptoConfig{SignKey: priv}}, nil)
+	cm := NewManagerWithConfig(&Config{MsgHandler: &echoHandler{client: true}, Crypto: &CryptoConfig{ServerKey: otherPub}})
+	c, _ := NewConnector("tcp", addr, WithReconnect(false))
+	cm.AddConnector(c)
+	c.(*connector).run()
+	if c.Session() != nil || sh.open.Load() != 0 {
+		t.Fatal("handshake with wrong server key")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/asynkron/protoactor-go/actor"
 	"github.com/murang/potato/log"
 )
 
@@ -20,14 +21,61 @@ const (
 )
 
 type Session struct {
-	manager     *Manager
-	id          uint64
-	conn        net.Conn
-	connGuard   sync.RWMutex
-	exitSync    sync.WaitGroup
-	sendChan    chan any
-	sendRawChan chan []byte
-	state       int64 //正常情况是0 主动关闭是1 出错关闭是2
+	manager      *Manager
+	id           uint64
+	link         *sessionLink // 当前使用的连接
+	connGuard    sync.RWMutex
+	exitSync     sync.WaitGroup
+	sendChan     chan any                  // 发送队列 消息和SendRaw的数据按顺序排队
+	ctrlChan     chan []byte               // 心跳等控制帧 已经带了帧头
+	linkDownChan chan *sessionLink         // 读循环结束时通知写循环
+	closeChan    chan struct{}             // Close时关闭 通知写循环退出
+	flushStart   chan struct{}             // CloseAfterFlush开始时关闭 唤醒阻塞的Send
+	flushChan    chan struct{}             // CloseAfterFlush时关闭 通知写循环写完队列后退出
+	sendGuard    sync.RWMutex              // Send和CloseAfterFlush互斥
+	exitChan     chan struct{}             // 读写循环都结束后关闭
+	isClient     bool                      // 是否是连接器主动连接生成的session
+	codec        ICodec                    // 编解码 监听器或者协商的结果 默认为Config.Codec
+	ip           string                    // 对方的ip
+	limit        *sessionLimit             // 限流 没有设置时为nil
+	rtt          int64                     // 平滑后的往返时间 纳秒
+	missedPong   int32                     // 连续没有收到pong的次数
+	resume       *sessionResume            // 断线恢复 没有开启时为nil
+	groups       sync.Map                  // 所在的组 group name -> *Group
+	attrs        sync.Map                  // 自定义属性 key -> value
+	userID       any                       // 绑定的用户 由manager.binding.mu保护
+	pid          atomic.Pointer[actor.PID] // actor模式下session actor的PID
+	requests     sync.Map                  // 正在处理的请求 req -> seq 处理完就删除
+	requestCount int32                     // requests中的请求数
+	calls        sync.Map                  // 等待回复的Call seq -> chan *Envelope
+	callSeq      uint32
+	compress     int32  // 发送时使用的压缩算法
+	peerCompress int32  // 对方可以解压的算法 frameCompress中的掩码
+	sendPeak     int32  // 发送队列中消息数的最大值
+	sendCount    uint64 // 已经写出的消息数
+	dropCount    uint64 // 发送队列满了丢弃的消息数
+	flushing     int32  // 调用过CloseAfterFlush
+	state        int64  //正常情况是0 主动关闭是1 出错关闭是2
+}
+
+// 一条底层连接 断线恢复时session会换上新的连接
+type sessionLink struct {
+	conn      net.Conn
+	framer    IFramer // 封包格式 文本帧的ws连接不使用Config.Framer
+	secure    *SecureChannel
+	fatal     bool          // 读循环因为非连接原因(比如解码失败)退出 不能恢复
+	peerRecv  uint64        // 恢复时对方已经收到的消息数
+	fragments []byte        // 正在拼接的分片
+	batch     *batchWriter  // 合并写 没有开启时为nil 只在写循环中使用
+	readDone  chan struct{} // 读循环结束时关闭
+}
+
+func newSessionLink(conn net.Conn, framer IFramer) *sessionLink {
+	return &sessionLink{
+		conn:     conn,
+		framer:   framer,
+		readDone: make(chan struct{}),
+	}
 }
 
 type SessionEvent struct {
@@ -36,16 +84,28 @@ type SessionEvent struct {
 	Msg     interface{}
 }
 
-func (s *Session) setConn(conn net.Conn) {
+// 发送使用的writer 开启合并写时先写进缓冲区
+func (l *sessionLink) writer() io.Writer {
+	if l.batch != nil {
+		return l.batch
+	}
+	return l.conn
+}
+
+func (s *Session) setLink(link *sessionLink) {
 	s.connGuard.Lock()
-	s.conn = conn
+	s.link = link
 	s.connGuard.Unlock()
 }
 
-func (s *Session) Conn() net.Conn {
+func (s *Session) currentLink() *sessionLink {
 	s.connGuard.RLock()
 	defer s.connGuard.RUnlock()
-	return s.conn
+	return s.link
+}
+
+func (s *Session) Conn() net.Conn {
+	return s.currentLink().conn
 }
 
 func (s *Session) ID() uint64 {
@@ -60,203 +120,520 @@ func (s *Session) Close() {
 	if !atomic.CompareAndSwapInt64(&s.state, 0, 2) {
 		return
 	}
-	s.connGuard.Lock()
-	conn := s.conn
-	s.connGuard.Unlock()
+	close(s.closeChan)
+	conn := s.Conn()
 	if conn != nil {
 		conn.SetDeadline(time.Now())
 		conn.Close()
 	}
 }
 
-func (s *Session) Send(msg interface{}) {
+// Send 发送消息 队列满了按照Config.SendQueue的策略处理
+func (s *Session) Send(msg interface{}) error {
 	if msg == nil {
-		return
-	}
-	// 已经关闭，不再发送
-	if s.IsClosed() {
-		return
+		return nil
 	}
-	s.sendChan <- msg
+	return s.enqueue(msg)
 }
 
-func (s *Session) SendRaw(data []byte) {
+// SendRaw 发送已经编码好的数据 和Send共用一个队列
+func (s *Session) SendRaw(data []byte) error {
 	if data == nil {
-		return
-	}
-	// 已经关闭，不再发送
-	if s.IsClosed() {
-		return
+		return nil
 	}
-	s.sendRawChan <- data
+	return s.enqueue(rawData(data))
 }
 
 func (s *Session) IsClosed() bool {
 	return atomic.LoadInt64(&s.state) != 0
 }
 
+// 传输加密握手 需要在Start之前完成 没有设置加密时直接返回
+func (s *Session) handshake() (err error) {
+	if s.manager.crypto == nil {
+		return nil
+	}
+	link := s.currentLink()
+	conn := link.conn
+	if err = conn.SetDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
+		return
+	}
+	if s.isClient {
+		link.secure, err = ClientHandshake(conn, link.framer, s.manager.crypto.ServerKey)
+	} else {
+		link.secure, err = ServerHandshake(conn, link.framer, s.manager.crypto.Cipher, s.manager.crypto.SignKey)
+	}
+	if err != nil {
+		return
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
 func (s *Session) Start() {
 
 	atomic.StoreInt64(&s.state, 0)
 
-	// 需要接收和发送线程同时完成时才算真正的完成
+	// 需要接收和发送线程同时完成时才算真正的完成 断线恢复时会启动新的接收线程
 	s.exitSync.Add(2)
 	go func() {
-		// 等待2个任务结束
+		// 等待所有任务结束
 		s.exitSync.Wait()
 		s.Close()
-		if s.manager.msgHandler != nil && s.manager.msgHandler.IsMsgInRoutine() {
-			s.manager.sessionMap.Delete(s.ID())
-			s.manager.connMu.Lock()
-			s.manager.sessionCount--
-			s.manager.connMu.Unlock()
-			log.Sugar.Infof("session close: %d", s.ID())
-			s.manager.msgHandler.OnSessionClose(s)
-		} else {
-			s.manager.sessionEventChan <- &SessionEvent{
-				Session: s,
-				Type:    SessionClose,
-			}
+		close(s.exitChan)
+		s.leaveGroups()
+		s.manager.unbindClosed(s)
+		if s.resume != nil {
+			s.manager.resumeMap.Delete(string(s.resume.token))
 		}
+		s.manager.postEvent(&SessionEvent{
+			Session: s,
+			Type:    SessionClose,
+		})
 	}()
 
-	if s.manager.msgHandler != nil && s.manager.msgHandler.IsMsgInRoutine() {
-		s.manager.sessionMap.Store(s.ID(), s)
-		s.manager.connMu.Lock()
-		s.manager.sessionCount++
-		s.manager.connMu.Unlock()
-		log.Sugar.Infof("session open: %d", s.ID())
-		s.manager.msgHandler.OnSessionOpen(s)
-	} else {
-		s.manager.sessionEventChan <- &SessionEvent{
-			Session: s,
-			Type:    SessionOpen,
-		}
-	}
+	s.manager.postEvent(&SessionEvent{
+		Session: s,
+		Type:    SessionOpen,
+	})
+
+	s.sendCompressAccept()
 
 	// 启动并发接收goroutine
-	go s.readLoop()
+	go s.readLoop(s.currentLink())
 
 	// 启动并发发送goroutine
 	go s.writeLoop()
 }
 
-// 接收循环
-func (s *Session) readLoop() {
+// 接收循环 每条连接一个 结束后通知写循环决定是关闭session还是等待恢复
+func (s *Session) readLoop(link *sessionLink) {
+	defer s.exitSync.Done()
+
+	// 内置IFramer读出的包体来自缓冲池 解码后放回
+	pooled := pooledRead(link.framer, s.codec)
 
 	for !s.IsClosed() {
 
 		var msgBytes []byte
 		var err error
 
-		msgBytes, err = s.readMessageBytes()
+		msgBytes, err = s.readMessageBytes(link)
+		raw := msgBytes
+
+		if err == nil && !s.checkLimit(len(msgBytes)) {
+			if pooled {
+				ReleasePacket(raw)
+			}
+			link.fatal = true
+			break
+		}
+
+		// 有帧头的话先处理帧头 控制帧处理完直接读下一个包
+		// 帧解析出错(分片超过上限 解压失败等)是协议错误 重连恢复也没用
+		if err == nil && s.manager.frameHead {
+			if msgBytes, err = s.unpackFrame(link, msgBytes); err != nil {
+				link.fatal = true
+			} else if msgBytes == nil {
+				if pooled {
+					ReleasePacket(raw)
+				}
+				continue
+			}
+		}
 
 		if err != nil {
 			var ip string
-			if s.conn != nil {
-				addr := s.conn.RemoteAddr()
-				if addr != nil {
-					ip = addr.String()
-				}
+			if addr := link.conn.RemoteAddr(); addr != nil {
+				ip = addr.String()
 			}
-			if atomic.LoadInt64(&s.state) != 1 || !isClosedError(err) {
+			if !s.IsClosed() || !isClosedError(err) {
 				log.Sugar.Warnf("session read err, sesid: %d, err: %s ip: %s", s.ID(), err, ip)
 			}
-			s.sendChan <- nil //给写队列传空 用于关闭写队列
+			// 帧解析出错时读出的包体也要放回
+			if pooled && raw != nil {
+				ReleasePacket(raw)
+			}
 			break
 		}
 
-		msg, err := s.manager.codec.Decode(msgBytes)
+		msg, err := s.codec.Decode(msgBytes)
+		if pooled {
+			ReleasePacket(raw)
+		}
 		if err != nil {
 			log.Sugar.Errorf("decode msg error, sesid: %d, err: %s", s.ID(), err)
-			s.sendChan <- nil //给写队列传空 用于关闭写队列
+			link.fatal = true
 			break
 		}
-		if s.manager.msgHandler != nil && s.manager.msgHandler.IsMsgInRoutine() {
-			s.manager.msgHandler.OnMsg(s, msg)
-		} else {
-			s.manager.sessionEventChan <- &SessionEvent{
-				Session: s,
-				Type:    SessionMsg,
-				Msg:     msg,
+		if env, ok := msg.(*Envelope); ok {
+			if msg = s.onEnvelope(env); msg == nil {
+				continue
 			}
 		}
+		s.manager.postEvent(&SessionEvent{
+			Session: s,
+			Type:    SessionMsg,
+			Msg:     msg,
+		})
 	}
 
-	// 通知完成
-	s.exitSync.Done()
+	// 通知写循环连接已经断开
+	close(link.readDone)
+	select {
+	case s.linkDownChan <- link:
+	case <-s.closeChan:
+	}
 }
 
-func (s *Session) readMessageBytes() (msg []byte, err error) {
+func (s *Session) readMessageBytes(link *sessionLink) (msg []byte, err error) {
 	if s.manager.timeout != 0 {
-		if err = s.conn.SetReadDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
+		if err = link.conn.SetReadDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
 			return
 		}
 	}
 
-	reader, ok := s.Raw().(io.Reader)
+	reader, ok := link.conn.(io.Reader)
 
 	// 转换错误，或者连接已经关闭时退出
 	if !ok || reader == nil {
 		return nil, errors.New("reader cast error")
 	}
 
-	msg, err = ReadPacket(reader)
+	msg, err = link.framer.ReadFrame(reader)
 
 	if err != nil {
 		return
 	}
 
+	if link.secure != nil {
+		msg, err = link.secure.Open(msg)
+	}
+
 	return
 }
 
-// 发送循环
+// 发送循环 同时负责管理连接 连接断开后根据设置关闭session或者等待恢复
 func (s *Session) writeLoop() {
-loop:
-	for !s.IsClosed() {
+	defer s.exitSync.Done()
+
+	// 开启心跳的话定时发送ping
+	var pingChan <-chan time.Time
+	if s.manager.heartbeat != nil {
+		ticker := time.NewTicker(s.manager.heartbeat.Interval)
+		defer ticker.Stop()
+		pingChan = ticker.C
+	}
+
+	// 断线恢复相关
+	var attachChan chan *sessionLink
+	var graceTimer *time.Timer
+	var graceChan <-chan time.Time
+	if s.resume != nil {
+		attachChan = s.resume.attachChan
+	}
+	suspended := false
+	link := s.currentLink()
+
+	// 连接断开 可以恢复的话等待恢复 否则关闭session
+	linkDown := func(fatal bool) bool {
+		if fatal || !s.canResume() {
+			return false
+		}
+		if !suspended {
+			suspended = true
+			_ = link.conn.Close()
+			graceTimer = time.NewTimer(s.manager.resume.GraceWindow)
+			graceChan = graceTimer.C
+			s.resume.notifySuspend()
+			log.Sugar.Infof("session suspended: %d", s.ID())
+		}
+		return true
+	}
+
+	// 合并写 缓冲区有数据时 队列空了或者到了FlushDelay再写出
+	link.batch = s.manager.newBatchWriter(link.conn)
+	var batchTimer *time.Timer
+	var batchChan <-chan time.Time
+	stopBatchTimer := func() {
+		if batchTimer != nil {
+			batchTimer.Stop()
+			batchTimer, batchChan = nil, nil
+		}
+	}
+	flushBatch := func() bool {
+		stopBatchTimer()
+		if err := s.flushBatch(link); err != nil {
+			s.logSendError(err)
+			return linkDown(false)
+		}
+		return true
+	}
+	afterWrite := func() bool {
+		if link.batch == nil || link.batch.buffered() == 0 {
+			return true
+		}
+		if delay := s.manager.coalesce.FlushDelay; delay > 0 {
+			if batchTimer == nil {
+				batchTimer = time.NewTimer(delay)
+				batchChan = batchTimer.C
+			}
+			return true
+		}
+		if len(s.sendChan) > 0 || len(s.ctrlChan) > 0 {
+			return true
+		}
+		return flushBatch()
+	}
+
+	// 写控制帧 合并写时立刻写出 不让心跳等在缓冲区里 返回false表示需要关闭session
+	writeCtrl := func(ctrl []byte) bool {
+		if suspended {
+			return true
+		}
+		if err := s.sendMessageBytes(link, ctrl); err != nil {
+			return linkDown(false)
+		}
+		if link.batch != nil && link.batch.buffered() > 0 {
+			return flushBatch()
+		}
+		return true
+	}
+
+	defer func() {
+		if graceTimer != nil {
+			graceTimer.Stop()
+		}
+		stopBatchTimer()
+	}()
+
+	for {
+		// 控制帧优先 下面的select是随机选的 业务消息多的时候心跳会被排在后面 rtt不准还可能误判超时
+		select {
+		case ctrl := <-s.ctrlChan:
+			if !writeCtrl(ctrl) {
+				s.Close()
+				return
+			}
+			continue
+		default:
+		}
+
 		var msgBytes []byte
 		select {
-		case raw := <-s.sendRawChan:
-			msgBytes = raw
-		case msg := <-s.sendChan:
-			if msg == nil { //在读loop的时候出错 这边需要break关闭
-				break loop
+		case <-s.closeChan:
+			return
+		case down := <-s.linkDownChan:
+			if down != link { // 已经换了新连接 旧连接的通知忽略
+				continue
+			}
+			if !linkDown(down.fatal) {
+				s.Close()
+				return
+			}
+			continue
+		case <-graceChan:
+			log.Sugar.Infof("session resume timeout: %d", s.ID())
+			s.Close()
+			return
+		case newLink := <-attachChan:
+			_ = link.conn.Close()
+			link = newLink
+			s.setLink(link)
+			stopBatchTimer()
+			link.batch = s.manager.newBatchWriter(link.conn)
+			if graceTimer != nil {
+				graceTimer.Stop()
+				graceTimer, graceChan = nil, nil
+			}
+			suspended = false
+			atomic.StoreInt32(&s.missedPong, 0)
+			s.exitSync.Add(1)
+			go s.readLoop(link)
+			log.Sugar.Infof("session resumed: %d", s.ID())
+			// 把对方没有收到的消息重新发一遍
+			if err := s.replay(link); err != nil {
+				log.Sugar.Warnf("session replay err: sesid: %d, err: %s", s.ID(), err.Error())
+				if !linkDown(errors.Is(err, ErrResumeSeq)) {
+					s.Close()
+					return
+				}
+				continue
+			}
+			if !afterWrite() {
+				s.Close()
+				return
+			}
+			continue
+		case <-batchChan:
+			batchTimer, batchChan = nil, nil
+			if !suspended && !flushBatch() {
+				s.Close()
+				return
+			}
+			continue
+		case <-s.flushChan:
+			if !suspended {
+				s.flush(link)
+				_ = s.flushBatch(link)
+			}
+			s.Close()
+			return
+		case <-pingChan:
+			if suspended {
+				continue
+			}
+			if !s.heartbeat() {
+				s.Close()
+				return
+			}
+			continue
+		case ctrl := <-s.ctrlChan:
+			if !writeCtrl(ctrl) {
+				s.Close()
+				return
 			}
-			data, err := s.manager.codec.Encode(msg)
+			continue
+		case item := <-s.sendChan:
+			if !suspended && s.canWriteDirect(link, item) {
+				atomic.AddUint64(&s.sendCount, 1)
+				if err := s.writeDirect(link, item); err != nil {
+					if errors.Is(err, errEncode) {
+						log.Sugar.Errorf("encode msg error, sesid: %d, err: %s", s.ID(), err)
+						s.Close()
+						return
+					}
+					// 超长的消息在写之前就检查了 连接没有问题 只丢弃这一条
+					if errors.Is(err, ErrMaxPacket) {
+						log.Sugar.Errorf("drop oversize msg, sesid: %d, err: %s", s.ID(), err)
+						continue
+					}
+					s.logSendError(err)
+					if !linkDown(false) {
+						s.Close()
+						return
+					}
+					continue
+				}
+				if !afterWrite() {
+					s.Close()
+					return
+				}
+				continue
+			}
+			data, err := s.encodeItem(item)
 			if err != nil {
 				log.Sugar.Errorf("encode msg error, sesid: %d, err: %s", s.ID(), err)
-				break loop
+				s.Close()
+				return
 			}
 			msgBytes = data
 		}
 
-		if err := s.sendMessageBytes(msgBytes); err != nil {
-			if atomic.LoadInt64(&s.state) != 1 || !isClosedError(err) {
-				log.Sugar.Warnf("session sendLoop sendMessage err: sesid: %d, err: %s", s.ID(), err.Error())
+		// 超过封包长度限制的消息发不出去 重连也没用 丢弃这一条 不能放进未确认队列也不能当作断线
+		pkts := s.dataPackets(msgBytes)
+		if err := s.checkPacketSize(link, pkts); err != nil {
+			log.Sugar.Errorf("drop oversize msg, sesid: %d, size: %d, err: %s", s.ID(), len(msgBytes), err)
+			continue
+		}
+		atomic.AddUint64(&s.sendCount, 1)
+
+		// 开启断线恢复的话 消息先放进未确认队列 断线期间只缓存不发送
+		if s.resume != nil {
+			if !s.resume.push(msgBytes) && suspended {
+				log.Sugar.Warnf("session resume buffer overflow, sesid: %d", s.ID())
+				s.Close()
+				return
 			}
-			break
+			if suspended {
+				continue
+			}
+		}
+		if err := s.sendPackets(link, pkts); err != nil {
+			s.logSendError(err)
+			if !linkDown(false) {
+				s.Close()
+				return
+			}
+			continue
 		}
+		if !afterWrite() {
+			s.Close()
+			return
+		}
+	}
+}
+
+func (s *Session) logSendError(err error) {
+	if !s.IsClosed() || !isClosedError(err) {
+		log.Sugar.Warnf("session sendLoop sendMessage err: sesid: %d, err: %s", s.ID(), err.Error())
 	}
+}
+
+// 发送队列中的消息编码 SendRaw的数据已经编码好了
+func (s *Session) encodeItem(item any) ([]byte, error) {
+	if raw, ok := item.(rawData); ok {
+		return raw, nil
+	}
+	return s.codec.Encode(item)
+}
 
-	// 通知完成
-	s.exitSync.Done()
+// 发送一条业务消息
+func (s *Session) sendData(link *sessionLink, data []byte) error {
+	return s.sendPackets(link, s.dataPackets(data))
 }
 
-func (s *Session) sendMessageBytes(msg []byte) (err error) {
+// 业务消息要发送的包 有帧头的话加上帧头 需要的话压缩和分片
+func (s *Session) dataPackets(data []byte) [][]byte {
+	if !s.manager.frameHead {
+		return [][]byte{data}
+	}
+	return s.splitFrame(s.packData(data))
+}
+
+func (s *Session) sendPackets(link *sessionLink, pkts [][]byte) error {
+	for _, pkt := range pkts {
+		if err := s.sendMessageBytes(link, pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 检查包(加密之后)有没有超过封包格式的长度限制
+func (s *Session) checkPacketSize(link *sessionLink, pkts [][]byte) error {
+	overhead := 0
+	if link.secure != nil {
+		overhead = link.secure.sealer.Overhead()
+	}
+	// ws的长度由对方的读取上限决定 不检查
+	f, ok := link.framer.(IMaxPacketSize)
+	if !ok {
+		return nil
+	}
+	for _, pkt := range pkts {
+		if len(pkt)+overhead > f.MaxPacketSize() {
+			return ErrMaxPacket
+		}
+	}
+	return nil
+}
+
+func (s *Session) sendMessageBytes(link *sessionLink, msg []byte) (err error) {
 	if s.manager.timeout != 0 {
-		if err = s.conn.SetWriteDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
+		if err = link.conn.SetWriteDeadline(time.Now().Add(time.Duration(s.manager.timeout) * time.Second)); err != nil {
 			return
 		}
 	}
 
-	writer, ok := s.Raw().(io.Writer)
+	writer := link.writer()
 
-	// 转换错误，或者连接已经关闭时退出
-	if !ok || writer == nil {
-		return errors.New("writer cast error")
+	if link.secure != nil {
+		if msg, err = link.secure.Seal(msg); err != nil {
+			return
+		}
 	}
 
-	err = WritePacket(writer, msg)
+	err = link.framer.WriteFrame(writer, msg)
 	if err != nil {
 		return
 	}
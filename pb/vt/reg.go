@@ -1,6 +1,7 @@
 package vt
 
 import (
+	"errors"
 	"sync"
 
 	"github.com/murang/potato/util"
@@ -14,6 +15,8 @@ type VTProtoMessage interface {
 	SizeVT() int
 }
 
+var errSizeMismatch = errors.New("vt marshal size mismatch")
+
 // 内部函数类型
 type marshalFunc func(msg VTProtoMessage) ([]byte, error)
 type unmarshalFunc func(msg VTProtoMessage, b []byte) error
@@ -97,3 +100,28 @@ func Size(msg proto.Message) int {
 	}
 	return proto.Size(msg)
 }
+
+// 直接序列化到buf中 len(buf)需要和Size的结果一样 省去Marshal的分配
+func MarshalToSizedBuffer(msg proto.Message, buf []byte) error {
+	if v, ok := msg.(interface {
+		MarshalToSizedBufferVT([]byte) (int, error)
+	}); ok {
+		n, err := v.MarshalToSizedBufferVT(buf)
+		if err != nil {
+			return err
+		}
+		if n != len(buf) {
+			return errSizeMismatch
+		}
+		return nil
+	}
+	// 长度一样时直接写在buf中
+	out, err := proto.MarshalOptions{}.MarshalAppend(buf[:0], msg)
+	if err != nil {
+		return err
+	}
+	if len(out) != len(buf) {
+		return errSizeMismatch
+	}
+	return nil
+}
@@ -47,7 +47,7 @@ func getPool(t reflect.Type) *sync.Pool {
 				}
 			},
 		}
-		poolMap.Store(t, pool)
+		pool, _ = poolMap.LoadOrStore(t, pool) // 并发创建时使用先存进去的
 	}
 	return pool.(*sync.Pool)
 }